@@ -4,17 +4,20 @@ go 1.23
 
 toolchain go1.23.2
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/Masterminds/squirrel v1.5.4
+	github.com/ethereum/go-ethereum v1.13.15
+	github.com/google/uuid v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/u2u-labs/go-layerg-common v0.0.0-20250116043201-bbd9e24aa670
+)
 
 require (
-	github.com/Masterminds/squirrel v1.5.4 // indirect
 	github.com/btcsuite/btcd/btcec/v2 v2.2.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
-	github.com/ethereum/go-ethereum v1.13.15 // indirect
+	github.com/holiman/uint256 v1.2.4 // indirect
 	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0 // indirect
 	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	github.com/u2u-labs/go-layerg-common v0.0.0-20250116043201-bbd9e24aa670 // indirect
 	github.com/unicornultrafoundation/go-u2u v1.1.4 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
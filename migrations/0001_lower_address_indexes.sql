@@ -0,0 +1,21 @@
+-- Collection ids and owners are matched ignoring case, as
+-- lower(collection_id) = lower($1) and lower(owner) = lower($1), which plain
+-- indexes on those columns cannot serve. These expression indexes can.
+--
+-- CREATE INDEX CONCURRENTLY cannot run inside a transaction: apply this file
+-- statement by statement, e.g. with psql -f, not in a migration transaction.
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_721_collection_assets_lower_collection_id_idx
+    ON erc_721_collection_assets (lower(collection_id));
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_721_collection_assets_lower_owner_idx
+    ON erc_721_collection_assets (lower(owner));
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_1155_collection_assets_lower_collection_id_idx
+    ON erc_1155_collection_assets (lower(collection_id));
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_1155_collection_assets_lower_owner_idx
+    ON erc_1155_collection_assets (lower(owner));
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_20_collection_assets_lower_collection_id_idx
+    ON erc_20_collection_assets (lower(collection_id));
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_20_collection_assets_lower_owner_idx
+    ON erc_20_collection_assets (lower(owner));
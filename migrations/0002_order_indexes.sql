@@ -0,0 +1,21 @@
+-- Local pages are ordered by (created_at, id) by default, and by
+-- (updated_at, id) with OrderByUpdatedAt. Without matching indexes Postgres
+-- sorts every matching row to serve a page.
+--
+-- CREATE INDEX CONCURRENTLY cannot run inside a transaction: apply this file
+-- statement by statement, e.g. with psql -f, not in a migration transaction.
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_721_collection_assets_created_at_id_idx
+    ON erc_721_collection_assets (created_at, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_721_collection_assets_updated_at_id_idx
+    ON erc_721_collection_assets (updated_at, id);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_1155_collection_assets_created_at_id_idx
+    ON erc_1155_collection_assets (created_at, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_1155_collection_assets_updated_at_id_idx
+    ON erc_1155_collection_assets (updated_at, id);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_20_collection_assets_created_at_id_idx
+    ON erc_20_collection_assets (created_at, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS erc_20_collection_assets_updated_at_id_idx
+    ON erc_20_collection_assets (updated_at, id);
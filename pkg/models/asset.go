@@ -0,0 +1,56 @@
+// Package models holds the asset and collection types asset queries return
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AssetResponse interface {
+	Pagination[Erc721CollectionAsset] |
+		Pagination[Erc1155CollectionAsset] |
+		Pagination[Erc20CollectionAsset]
+}
+
+type Erc721CollectionAsset struct {
+	ID           uuid.UUID `json:"id"`
+	ChainID      int32     `json:"chainId"`
+	CollectionID string    `json:"collectionId"`
+	TokenID      string    `json:"tokenId"`
+	Owner        string    `json:"owner"`
+	Attributes   JSON      `json:"attributes"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	UpdatedBy    uuid.UUID `json:"updatedBy"`
+	Signature    string    `json:"signature"`
+}
+
+type Erc1155CollectionAsset struct {
+	ID           uuid.UUID `json:"id"`
+	ChainID      int32     `json:"chainId"`
+	CollectionID string    `json:"collectionId"`
+	TokenID      string    `json:"tokenId"`
+	Owner        string    `json:"owner"`
+	Balance      Uint256   `json:"balance"`
+	Attributes   JSON      `json:"attributes"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	UpdatedBy    uuid.UUID `json:"updatedBy"`
+	Signature    string    `json:"signature"`
+}
+
+type Erc20CollectionAsset struct {
+	ID           uuid.UUID `json:"id"`
+	ChainID      int32     `json:"chainId"`
+	CollectionID string    `json:"collectionId"`
+	Owner        string    `json:"owner"`
+	Balance      Uint256   `json:"balance"`
+	// Decimals and FormattedBalance are filled in from the collection on request
+	Decimals         *int      `json:"decimals,omitempty" db:"-"`
+	FormattedBalance string    `json:"formattedBalance,omitempty" db:"-"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	UpdatedBy        uuid.UUID `json:"updatedBy"`
+	Signature        string    `json:"signature"`
+}
@@ -22,5 +22,5 @@ type Collection struct {
 	UpdatedAt         time.Time      `json:"updatedAt"`
 	DecimalData       sql.NullInt16  `json:"decimalData"`
 	InitialBlock      sql.NullInt64  `json:"initialBlock"`
-	LastUpdated       sql.NullTime   `json:"lastUpdated"`
+	LastUpdated       NullTime       `json:"lastUpdated"`
 }
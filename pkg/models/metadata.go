@@ -0,0 +1,207 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// NFTMetadata is the metadata of an ERC721 or ERC1155 token, following the
+// common metadata conventions
+type NFTMetadata struct {
+	Name            string              `json:"name,omitempty"`
+	Description     string              `json:"description,omitempty"`
+	Image           string              `json:"image,omitempty"`
+	ExternalURL     string              `json:"external_url,omitempty"`
+	AnimationURL    string              `json:"animation_url,omitempty"`
+	BackgroundColor string              `json:"background_color,omitempty"`
+	Attributes      []MetadataAttribute `json:"attributes,omitempty"`
+}
+
+// MetadataAttribute is one trait of a token. Value is a string, a json.Number
+// or a bool.
+type MetadataAttribute struct {
+	TraitType   string      `json:"trait_type,omitempty"`
+	Value       interface{} `json:"value"`
+	DisplayType string      `json:"display_type,omitempty"`
+	MaxValue    interface{} `json:"max_value,omitempty"`
+}
+
+// Attribute returns the first attribute with the given trait type
+func (m NFTMetadata) Attribute(traitType string) (MetadataAttribute, bool) {
+	for _, attribute := range m.Attributes {
+		if attribute.TraitType == traitType {
+			return attribute, true
+		}
+	}
+	return MetadataAttribute{}, false
+}
+
+// Metadata decodes the attributes of the asset as NFT metadata
+func (a Erc721CollectionAsset) Metadata() (NFTMetadata, error) {
+	return ParseMetadata(a.Attributes)
+}
+
+// Metadata decodes the attributes of the asset as NFT metadata
+func (a Erc1155CollectionAsset) Metadata() (NFTMetadata, error) {
+	return ParseMetadata(a.Attributes)
+}
+
+// ParseMetadata leniently decodes the shapes found in the attributes column:
+//   - a metadata document with name, image, attributes and so on
+//   - a bare array of attributes, or of plain trait values
+//   - a flat object mapping trait types to values
+//   - any of the above encoded once more as a JSON string
+//
+// Common aliases such as traits, properties, traitType and image_url are
+// accepted. Empty attributes decode to empty metadata.
+func ParseMetadata(data JSON) (NFTMetadata, error) {
+	if data.IsNull() {
+		return NFTMetadata{}, nil
+	}
+
+	value, err := decodeLenient(data)
+	if err != nil {
+		return NFTMetadata{}, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	// Unwrap documents encoded as a JSON string
+	if s, ok := value.(string); ok {
+		trimmed := bytes.TrimSpace([]byte(s))
+		if len(trimmed) == 0 || !json.Valid(trimmed) {
+			return NFTMetadata{}, fmt.Errorf("invalid metadata: not a JSON document")
+		}
+		return ParseMetadata(JSON(trimmed))
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		return NFTMetadata{Attributes: parseAttributes(value)}, nil
+	case map[string]interface{}:
+		if isMetadataDocument(value) {
+			return parseMetadataDocument(value), nil
+		}
+		return NFTMetadata{Attributes: parseTraitMap(value)}, nil
+	}
+
+	return NFTMetadata{}, fmt.Errorf("invalid metadata: unexpected %T", value)
+}
+
+// decodeLenient decodes data keeping numbers as json.Number
+func decodeLenient(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+var metadataKeys = []string{
+	"name", "description", "image", "image_url", "imageUrl", "image_data",
+	"external_url", "externalUrl", "animation_url", "animationUrl",
+	"attributes", "traits", "properties",
+}
+
+// isMetadataDocument reports whether object uses any metadata key, rather
+// than being a flat map of traits
+func isMetadataDocument(object map[string]interface{}) bool {
+	for _, key := range metadataKeys {
+		if _, ok := object[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMetadataDocument(object map[string]interface{}) NFTMetadata {
+	metadata := NFTMetadata{
+		Name:            firstString(object, "name"),
+		Description:     firstString(object, "description"),
+		Image:           firstString(object, "image", "image_url", "imageUrl", "image_data"),
+		ExternalURL:     firstString(object, "external_url", "externalUrl"),
+		AnimationURL:    firstString(object, "animation_url", "animationUrl"),
+		BackgroundColor: firstString(object, "background_color", "backgroundColor"),
+	}
+
+	for _, key := range []string{"attributes", "traits", "properties"} {
+		switch attributes := object[key].(type) {
+		case []interface{}:
+			metadata.Attributes = append(metadata.Attributes, parseAttributes(attributes)...)
+		case map[string]interface{}:
+			metadata.Attributes = append(metadata.Attributes, parseTraitMap(attributes)...)
+		}
+	}
+	return metadata
+}
+
+// parseAttributes decodes an array of attribute objects or plain values
+func parseAttributes(values []interface{}) []MetadataAttribute {
+	attributes := make([]MetadataAttribute, 0, len(values))
+	for _, value := range values {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			if value != nil {
+				attributes = append(attributes, MetadataAttribute{Value: value})
+			}
+			continue
+		}
+
+		attribute := MetadataAttribute{
+			TraitType:   firstString(object, "trait_type", "traitType", "type", "key", "name"),
+			DisplayType: firstString(object, "display_type", "displayType"),
+			Value:       object["value"],
+			MaxValue:    firstValue(object, "max_value", "maxValue"),
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// parseTraitMap decodes an object mapping trait types to values, ordered by
+// trait type. ERC1155 style {"value": ..., "display_type": ...} entries are
+// unwrapped.
+func parseTraitMap(object map[string]interface{}) []MetadataAttribute {
+	traitTypes := make([]string, 0, len(object))
+	for traitType := range object {
+		traitTypes = append(traitTypes, traitType)
+	}
+	sort.Strings(traitTypes)
+
+	attributes := make([]MetadataAttribute, 0, len(traitTypes))
+	for _, traitType := range traitTypes {
+		attribute := MetadataAttribute{TraitType: traitType, Value: object[traitType]}
+		if nested, ok := object[traitType].(map[string]interface{}); ok {
+			if value, ok := nested["value"]; ok {
+				attribute.Value = value
+				attribute.DisplayType = firstString(nested, "display_type", "displayType")
+				attribute.MaxValue = firstValue(nested, "max_value", "maxValue")
+			}
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// firstString returns the first of keys holding a string in object
+func firstString(object map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := object[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// firstValue returns the first of keys holding a value in object
+func firstValue(object map[string]interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if value, ok := object[key]; ok && value != nil {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want NFTMetadata
+	}{
+		{"null", `null`, NFTMetadata{}},
+		{"empty", ``, NFTMetadata{}},
+		{
+			"full document",
+			`{
+				"name": "Token 1",
+				"description": "The first token",
+				"image": "ipfs://image",
+				"external_url": "https://example.com/1",
+				"animation_url": "ipfs://animation",
+				"background_color": "ffffff",
+				"attributes": [
+					{"trait_type": "eyes", "value": "blue"},
+					{"trait_type": "level", "value": 5, "display_type": "number", "max_value": 10},
+					{"trait_type": "rare", "value": true}
+				]
+			}`,
+			NFTMetadata{
+				Name:            "Token 1",
+				Description:     "The first token",
+				Image:           "ipfs://image",
+				ExternalURL:     "https://example.com/1",
+				AnimationURL:    "ipfs://animation",
+				BackgroundColor: "ffffff",
+				Attributes: []MetadataAttribute{
+					{TraitType: "eyes", Value: "blue"},
+					{TraitType: "level", Value: json.Number("5"), DisplayType: "number", MaxValue: json.Number("10")},
+					{TraitType: "rare", Value: true},
+				},
+			},
+		},
+		{
+			"bare attribute array",
+			`[{"trait_type": "eyes", "value": "blue"}, {"trait_type": "mouth", "value": "smile"}]`,
+			NFTMetadata{Attributes: []MetadataAttribute{
+				{TraitType: "eyes", Value: "blue"},
+				{TraitType: "mouth", Value: "smile"},
+			}},
+		},
+		{
+			"plain value array",
+			`["blue", 3, null, false]`,
+			NFTMetadata{Attributes: []MetadataAttribute{
+				{Value: "blue"},
+				{Value: json.Number("3")},
+				{Value: false},
+			}},
+		},
+		{
+			"flat trait map",
+			`{"mouth": "smile", "eyes": "blue", "level": 2}`,
+			NFTMetadata{Attributes: []MetadataAttribute{
+				{TraitType: "eyes", Value: "blue"},
+				{TraitType: "level", Value: json.Number("2")},
+				{TraitType: "mouth", Value: "smile"},
+			}},
+		},
+		{
+			"erc1155 nested values",
+			`{"name": "Sword", "properties": {
+				"damage": {"value": 12, "display_type": "boost_number", "max_value": 20},
+				"element": {"value": "fire"},
+				"origin": {"forge": "north"}
+			}}`,
+			NFTMetadata{Name: "Sword", Attributes: []MetadataAttribute{
+				{TraitType: "damage", Value: json.Number("12"), DisplayType: "boost_number", MaxValue: json.Number("20")},
+				{TraitType: "element", Value: "fire"},
+				{TraitType: "origin", Value: map[string]interface{}{"forge": "north"}},
+			}},
+		},
+		{
+			"double encoded",
+			`"{\"name\": \"Token 2\", \"attributes\": [{\"trait_type\": \"eyes\", \"value\": \"green\"}]}"`,
+			NFTMetadata{Name: "Token 2", Attributes: []MetadataAttribute{
+				{TraitType: "eyes", Value: "green"},
+			}},
+		},
+		{
+			"key aliases",
+			`{
+				"name": "Token 3",
+				"imageUrl": "ipfs://image",
+				"externalUrl": "https://example.com/3",
+				"animationUrl": "ipfs://animation",
+				"backgroundColor": "000000",
+				"traits": [
+					{"traitType": "eyes", "value": "red", "displayType": "string"},
+					{"type": "hat", "value": "cap"},
+					{"key": "level", "value": 7, "maxValue": 9}
+				]
+			}`,
+			NFTMetadata{
+				Name:            "Token 3",
+				Image:           "ipfs://image",
+				ExternalURL:     "https://example.com/3",
+				AnimationURL:    "ipfs://animation",
+				BackgroundColor: "000000",
+				Attributes: []MetadataAttribute{
+					{TraitType: "eyes", Value: "red", DisplayType: "string"},
+					{TraitType: "hat", Value: "cap"},
+					{TraitType: "level", Value: json.Number("7"), MaxValue: json.Number("9")},
+				},
+			},
+		},
+		{
+			"image_url when image is empty",
+			`{"image": "", "image_url": "https://example.com/image.png"}`,
+			NFTMetadata{Image: "https://example.com/image.png"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseMetadata(JSON(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadataErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"truncated", `{"name": "Token`},
+		{"number", `42`},
+		{"bool", `true`},
+		{"plain string", `"just a name"`},
+		{"empty string", `""`},
+		{"encoded number", `"42"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := ParseMetadata(JSON(test.data)); err == nil {
+				t.Errorf("got %#v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestMetadataAttribute(t *testing.T) {
+	metadata := NFTMetadata{Attributes: []MetadataAttribute{
+		{TraitType: "eyes", Value: "blue"},
+		{TraitType: "eyes", Value: "green"},
+	}}
+
+	if attribute, ok := metadata.Attribute("eyes"); !ok || attribute.Value != "blue" {
+		t.Errorf("got %#v, %t, want the first eyes attribute", attribute, ok)
+	}
+	if attribute, ok := metadata.Attribute("hat"); ok {
+		t.Errorf("got %#v for a missing trait", attribute)
+	}
+}
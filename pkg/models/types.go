@@ -0,0 +1,273 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUint256 is 2^256 - 1
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// Uint256 is an unsigned 256-bit integer such as a token balance. It is stored
+// as text holding the decimal integer, as the balance columns of the asset
+// tables are, and encoded in JSON as a decimal string, so no precision is lost
+// in clients. The zero value is 0.
+type Uint256 struct {
+	v *big.Int
+}
+
+// NewUint256 returns x as a Uint256. x is copied.
+func NewUint256(x *big.Int) (Uint256, error) {
+	if x == nil {
+		return Uint256{}, nil
+	}
+	if x.Sign() < 0 || x.Cmp(maxUint256) > 0 {
+		return Uint256{}, fmt.Errorf("uint256 out of range: %s", x)
+	}
+	return Uint256{v: new(big.Int).Set(x)}, nil
+}
+
+// ParseUint256 parses a base 10 unsigned integer
+func ParseUint256(s string) (Uint256, error) {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Uint256{}, fmt.Errorf("invalid uint256 %q", s)
+	}
+	return NewUint256(x)
+}
+
+// Big returns a copy of the value as a *big.Int
+func (u Uint256) Big() *big.Int {
+	if u.v == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(u.v)
+}
+
+// String returns the value in base 10
+func (u Uint256) String() string {
+	if u.v == nil {
+		return "0"
+	}
+	return u.v.String()
+}
+
+// ParseUnits parses a decimal amount such as "1.5" expressed in units of
+// decimals into its raw integer value, exactly. Amounts with more fractional
+// digits than decimals are rejected rather than rounded.
+func ParseUnits(amount string, decimals int) (Uint256, error) {
+	if decimals < 0 {
+		return Uint256{}, fmt.Errorf("invalid decimals %d", decimals)
+	}
+
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	fraction = strings.TrimRight(fraction, "0")
+	if whole == "" || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return Uint256{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	if len(fraction) > decimals {
+		return Uint256{}, fmt.Errorf("amount %q has more than %d decimals", amount, decimals)
+	}
+
+	raw, err := ParseUint256(whole + fraction + strings.Repeat("0", decimals-len(fraction)))
+	if err != nil {
+		return Uint256{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	return raw, nil
+}
+
+// Format returns the value as a decimal amount in units of decimals, without
+// trailing zeros, such as "1.5" for 1500000 with 6 decimals
+func (u Uint256) Format(decimals int) string {
+	digits := u.String()
+	if decimals <= 0 {
+		return digits
+	}
+
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole := digits[:len(digits)-decimals]
+	fraction := strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
+
+// Scan implements sql.Scanner. NULL scans as 0.
+func (u *Uint256) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*u = Uint256{}
+		return nil
+	case int64:
+		parsed, err := NewUint256(big.NewInt(src))
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	case []byte:
+		return u.Scan(string(src))
+	case string:
+		parsed, err := ParseUint256(src)
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Uint256", src)
+}
+
+// Value implements driver.Valuer
+func (u Uint256) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// MarshalJSON encodes the value as a decimal string
+func (u Uint256) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.String())
+}
+
+// UnmarshalJSON accepts a decimal string, a JSON number or null
+func (u *Uint256) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*u = Uint256{}
+		return nil
+	}
+
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	parsed, err := ParseUint256(s)
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+// JSON is a raw JSON document such as the attributes of an asset. It is
+// encoded as the document itself rather than as a string, and as null when
+// empty.
+type JSON json.RawMessage
+
+// IsNull reports whether the document is empty or null
+func (j JSON) IsNull() bool {
+	return len(j) == 0 || bytes.Equal(j, []byte("null"))
+}
+
+// Decode unmarshals the document into v
+func (j JSON) Decode(v interface{}) error {
+	if j.IsNull() {
+		return nil
+	}
+	return json.Unmarshal(j, v)
+}
+
+// Scan implements sql.Scanner. Text that is not valid JSON is kept as a JSON
+// string, so the document is always valid.
+func (j *JSON) Scan(src interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		*j = nil
+		return nil
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", src)
+	}
+
+	*j = toJSON(data)
+	return nil
+}
+
+// Value implements driver.Valuer
+func (j JSON) Value() (driver.Value, error) {
+	if j.IsNull() {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+// MarshalJSON encodes the document as is
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j.IsNull() {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// UnmarshalJSON keeps the document. A JSON string holding a JSON document, as
+// sent by the master for text columns, is unwrapped.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s == "" {
+			*j = nil
+			return nil
+		}
+		*j = toJSON([]byte(s))
+		return nil
+	}
+
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+// toJSON copies data when it is a valid JSON document and encodes it as a
+// JSON string otherwise
+func toJSON(data []byte) JSON {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	if json.Valid(trimmed) {
+		return append(JSON(nil), trimmed...)
+	}
+
+	encoded, _ := json.Marshal(string(data))
+	return encoded
+}
+
+// NullTime is a nullable timestamp encoded in JSON as a timestamp or null
+type NullTime struct {
+	sql.NullTime
+}
+
+// MarshalJSON encodes the timestamp, or null when it is not valid
+func (t NullTime) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time)
+}
+
+// UnmarshalJSON accepts a timestamp or null
+func (t *NullTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*t = NullTime{}
+		return nil
+	}
+
+	var parsed time.Time
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*t = NullTime{sql.NullTime{Time: parsed, Valid: true}}
+	return nil
+}
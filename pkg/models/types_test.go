@@ -0,0 +1,348 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const maxUint256String = "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+
+func TestParseUint256(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"0", "0", false},
+		{"42", "42", false},
+		{"007", "7", false},
+		{maxUint256String, maxUint256String, false},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639936", "", true},
+		{"-1", "", true},
+		{"0x10", "", true},
+		{"1.5", "", true},
+		{"", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseUint256(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseUint256(%q) = %s, want an error", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseUint256(%q): %v", test.s, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("ParseUint256(%q) = %s, want %s", test.s, got, test.want)
+		}
+	}
+}
+
+func TestNewUint256Copies(t *testing.T) {
+	x := big.NewInt(5)
+	value, err := NewUint256(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.SetInt64(6)
+	value.Big().SetInt64(7)
+
+	if value.String() != "5" {
+		t.Errorf("got %s after changing the source and the copy, want 5", value)
+	}
+	if zero := (Uint256{}); zero.String() != "0" || zero.Big().Sign() != 0 {
+		t.Errorf("zero value is %s, want 0", zero)
+	}
+}
+
+func TestUint256Scan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    string
+		wantErr bool
+	}{
+		{"null", nil, "0", false},
+		{"int64", int64(12), "12", false},
+		{"bytes", []byte("340282366920938463463374607431768211456"), "340282366920938463463374607431768211456", false},
+		{"string", maxUint256String, maxUint256String, false},
+		{"negative int64", int64(-1), "", true},
+		{"not a number", "abc", "", true},
+		{"float", 1.5, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, _ := ParseUint256("99") // Scanning replaces any previous value
+			err := value.Scan(test.src)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("scanned %v as %s, want an error", test.src, value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.String() != test.want {
+				t.Errorf("scanned %v as %s, want %s", test.src, value, test.want)
+			}
+		})
+	}
+}
+
+func TestUint256JSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{`"1500000"`, "1500000", false},
+		{`1500000`, "1500000", false},
+		{`null`, "0", false},
+		{`"` + maxUint256String + `"`, maxUint256String, false},
+		{`"-1"`, "", true},
+		{`"1.5"`, "", true},
+		{`true`, "", true},
+	}
+
+	for _, test := range tests {
+		var value Uint256
+		err := json.Unmarshal([]byte(test.data), &value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("decoded %s as %s, want an error", test.data, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decoding %s: %v", test.data, err)
+			continue
+		}
+		if value.String() != test.want {
+			t.Errorf("decoded %s as %s, want %s", test.data, value, test.want)
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != `"`+test.want+`"` {
+			t.Errorf("encoded %s as %s, want the decimal string %q", test.data, encoded, test.want)
+		}
+	}
+}
+
+func TestJSONScan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  interface{}
+		want string
+	}{
+		{"null", nil, "null"},
+		{"empty", "", "null"},
+		{"document", []byte(` [{"trait_type":"eyes"}] `), `[{"trait_type":"eyes"}]`},
+		{"text", "not json", `"not json"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value JSON
+			if err := value.Scan(test.src); err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != test.want {
+				t.Errorf("scanned %v as %s, want %s", test.src, encoded, test.want)
+			}
+		})
+	}
+}
+
+func TestJSONUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"document", `{"name":"a"}`, `{"name":"a"}`},
+		{"encoded document", `"{\"name\":\"a\"}"`, `{"name":"a"}`},
+		{"text", `"plain"`, `"plain"`},
+		{"empty string", `""`, "null"},
+		{"null", `null`, "null"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value struct {
+				Attributes JSON `json:"attributes"`
+			}
+			if err := json.Unmarshal([]byte(`{"attributes":`+test.data+`}`), &value); err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := json.Marshal(value.Attributes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != test.want {
+				t.Errorf("decoded %s as %s, want %s", test.data, encoded, test.want)
+			}
+		})
+	}
+}
+
+func TestNullTimeJSON(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value NullTime
+		want  string
+	}{
+		{"null", NullTime{}, `null`},
+		{"valid", NullTime{sql.NullTime{Time: at, Valid: true}}, `"2024-05-01T12:00:00Z"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := json.Marshal(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != test.want {
+				t.Errorf("encoded %+v as %s, want %s", test.value, encoded, test.want)
+			}
+
+			var decoded NullTime
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Valid != test.value.Valid || !decoded.Time.Equal(test.value.Time) {
+				t.Errorf("decoded %s as %+v, want %+v", encoded, decoded, test.value)
+			}
+		})
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     string
+	}{
+		{"0", 18, "0"},
+		{"1", 0, "1"},
+		{"1", 6, "1000000"},
+		{"1.5", 6, "1500000"},
+		{"0.000001", 6, "1"},
+		{"1.500000", 6, "1500000"},
+		{"1.5000000000", 6, "1500000"}, // Trailing zeros past the decimals
+		{"1.", 6, "1000000"},
+		{" 2.25 ", 2, "225"},
+		{"007", 2, "700"},
+		{"1000000000000000000", 18, "1000000000000000000000000000000000000"},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", 0,
+			"115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.amount, func(t *testing.T) {
+			got, err := ParseUnits(test.amount, test.decimals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("ParseUnits(%q, %d) = %s, want %s", test.amount, test.decimals, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseUnitsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		decimals int
+	}{
+		{"empty", "", 18},
+		{"fraction only", ".5", 18},
+		{"negative", "-1", 18},
+		{"signed", "+1", 18},
+		{"too many decimals", "1.0000001", 6},
+		{"decimals on integer units", "1.5", 0},
+		{"negative decimals", "1", -1},
+		{"letters", "1a", 18},
+		{"exponent", "1e18", 18},
+		{"two points", "1.2.3", 18},
+		{"overflow", "115792089237316195423570985008687907853269984665640564039457584007913129639936", 0},
+		{"overflow with decimals", "115792089237316195423570985008687907853269984665640564039457584007913129639935", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := ParseUnits(test.amount, test.decimals); err == nil {
+				t.Errorf("ParseUnits(%q, %d) = %s, want an error", test.amount, test.decimals, got)
+			}
+		})
+	}
+}
+
+func TestUint256Format(t *testing.T) {
+	tests := []struct {
+		raw      string
+		decimals int
+		want     string
+	}{
+		{"0", 0, "0"},
+		{"0", 18, "0"},
+		{"1", 0, "1"},
+		{"1", -1, "1"},
+		{"1", 6, "0.000001"},
+		{"1500000", 6, "1.5"},
+		{"1000000", 6, "1"},
+		{"123456789", 6, "123.456789"},
+		{"100", 2, "1"},
+		{"10", 2, "0.1"},
+		{"1000000000000000000", 18, "1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			value, err := ParseUint256(test.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := value.Format(test.decimals); got != test.want {
+				t.Errorf("%s.Format(%d) = %q, want %q", test.raw, test.decimals, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatParseUnitsRoundTrip(t *testing.T) {
+	for _, raw := range []string{"0", "1", "10", "999999", "1000000", "1234567890123456789"} {
+		for _, decimals := range []int{0, 1, 6, 18} {
+			value, err := ParseUint256(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseUnits(value.Format(decimals), decimals)
+			if err != nil {
+				t.Fatalf("ParseUnits(%q, %d): %v", value.Format(decimals), decimals, err)
+			}
+			if parsed.String() != raw {
+				t.Errorf("%s with %d decimals round trips to %s", raw, decimals, parsed)
+			}
+		}
+	}
+}
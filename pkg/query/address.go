@@ -0,0 +1,93 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"asset-query/pkg/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrInvalidAddress is returned when an owner or collection address is not a
+// 20-byte hex EVM address
+var ErrInvalidAddress = errors.New("invalid address")
+
+// normalizeAddress validates an EVM address and returns its EIP-55 0x form,
+// which is sent to the master as is. Local SQL matches addresses ignoring case.
+func normalizeAddress(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if !common.IsHexAddress(address) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	return common.HexToAddress(address).Hex(), nil
+}
+
+// normalizeCollectionId validates a "chainId:address" or bare address
+// collection id and returns the id with the address in EIP-55 form
+func normalizeCollectionId(collectionId string) (string, error) {
+	if !strings.Contains(collectionId, ":") {
+		return normalizeAddress(collectionId)
+	}
+
+	ref, err := ParseCollectionRef(collectionId)
+	if err != nil {
+		return "", err
+	}
+	return ref.String(), nil
+}
+
+// checksumAddress returns the EIP-55 form of address, or address unchanged
+// when it is not a valid address
+func checksumAddress(address string) string {
+	if !common.IsHexAddress(address) {
+		return address
+	}
+	return common.HexToAddress(address).Hex()
+}
+
+// checksumCollectionId returns collectionId with its address in EIP-55 form
+func checksumCollectionId(collectionId string) string {
+	prefix, address, found := strings.Cut(collectionId, ":")
+	if !found {
+		return checksumAddress(prefix)
+	}
+	return prefix + ":" + checksumAddress(address)
+}
+
+// checksumResult returns result with the owner and collection addresses of its
+// assets in EIP-55 form. The assets are copied, so cached results shared with
+// other callers are never modified.
+func checksumResult(result any) any {
+	switch page := result.(type) {
+	case Pagination[models.Erc721CollectionAsset]:
+		page.Data = checksumAssets(page.Data, func(asset *models.Erc721CollectionAsset) {
+			asset.Owner = checksumAddress(asset.Owner)
+			asset.CollectionID = checksumCollectionId(asset.CollectionID)
+		})
+		return page
+	case Pagination[models.Erc1155CollectionAsset]:
+		page.Data = checksumAssets(page.Data, func(asset *models.Erc1155CollectionAsset) {
+			asset.Owner = checksumAddress(asset.Owner)
+			asset.CollectionID = checksumCollectionId(asset.CollectionID)
+		})
+		return page
+	case Pagination[models.Erc20CollectionAsset]:
+		page.Data = checksumAssets(page.Data, func(asset *models.Erc20CollectionAsset) {
+			asset.Owner = checksumAddress(asset.Owner)
+			asset.CollectionID = checksumCollectionId(asset.CollectionID)
+		})
+		return page
+	}
+	return result
+}
+
+func checksumAssets[T any](assets []T, checksum func(asset *T)) []T {
+	assets = slices.Clone(assets)
+	for i := range assets {
+		checksum(&assets[i])
+	}
+	return assets
+}
@@ -0,0 +1,125 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"asset-query/pkg/models"
+)
+
+const (
+	ownerAddress      = "0x821dAb5C6fffD8183d4E3e4A5C1725c847c36789"
+	collectionAddress = "0x0091BD12166d29539Db6bb37FB79670779aBf266"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{"lower case", "0x821dab5c6fffd8183d4e3e4a5c1725c847c36789", ownerAddress, false},
+		{"upper case", "0x821DAB5C6FFFD8183D4E3E4A5C1725C847C36789", ownerAddress, false},
+		{"checksummed", ownerAddress, ownerAddress, false},
+		{"whitespace", "  0x821dab5c6fffd8183d4e3e4a5c1725c847c36789\n", ownerAddress, false},
+		{"without 0x", "821dab5c6fffd8183d4e3e4a5c1725c847c36789", ownerAddress, false},
+		{"empty", "", "", true},
+		{"invalid hex", "0x821dab5c6fffd8183d4e3e4a5c1725c847c3678z", "", true},
+		{"too short", "0x821dab5c6fffd8183d4e3e4a5c1725c847c367", "", true},
+		{"too long", "0x821dab5c6fffd8183d4e3e4a5c1725c847c3678900", "", true},
+		{"inner whitespace", "0x821dab5c6fffd8183d4e 3e4a5c1725c847c36789", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizeAddress(test.address)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("got %q and error %v, want %v", got, err, ErrInvalidAddress)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeCollectionId(t *testing.T) {
+	tests := []struct {
+		name         string
+		collectionId string
+		want         string
+		wantErr      bool
+	}{
+		{"chain and lower case", "1:0x0091bd12166d29539db6bb37fb79670779abf266", "1:" + collectionAddress, false},
+		{"chain and upper case", "1:0x0091BD12166D29539DB6BB37FB79670779ABF266", "1:" + collectionAddress, false},
+		{"whitespace", " 1:0x0091bd12166d29539db6bb37fb79670779abf266 ", "1:" + collectionAddress, false},
+		{"bare address", "0x0091bd12166d29539db6bb37fb79670779abf266", collectionAddress, false},
+		{"invalid hex", "1:0x0091bd12166d29539db6bb37fb79670779abf26g", "", true},
+		{"invalid bare address", "0x0091", "", true},
+		{"no address", "1:", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizeCollectionId(test.collectionId)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("got %q and error %v, want %v", got, err, ErrInvalidAddress)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChecksumResult(t *testing.T) {
+	cached := Pagination[models.Erc721CollectionAsset]{
+		Data: []models.Erc721CollectionAsset{
+			{TokenID: "1", Owner: "0x821dab5c6fffd8183d4e3e4a5c1725c847c36789", CollectionID: "1:0x0091bd12166d29539db6bb37fb79670779abf266"},
+			{TokenID: "2", Owner: "not an address", CollectionID: "0x0091bd12166d29539db6bb37fb79670779abf266"},
+		},
+	}
+
+	got, ok := checksumResult(cached).(Pagination[models.Erc721CollectionAsset])
+	if !ok {
+		t.Fatalf("got %T, want the page type unchanged", got)
+	}
+
+	want := []struct{ owner, collectionId string }{
+		{ownerAddress, "1:" + collectionAddress},
+		{"not an address", collectionAddress},
+	}
+	for i, asset := range got.Data {
+		if asset.Owner != want[i].owner || asset.CollectionID != want[i].collectionId {
+			t.Errorf("asset %d has owner %q and collection %q, want %q and %q",
+				i, asset.Owner, asset.CollectionID, want[i].owner, want[i].collectionId)
+		}
+	}
+
+	// The cached page shared with other callers is left as it was
+	if cached.Data[0].Owner != "0x821dab5c6fffd8183d4e3e4a5c1725c847c36789" ||
+		cached.Data[0].CollectionID != "1:0x0091bd12166d29539db6bb37fb79670779abf266" {
+		t.Errorf("cached asset modified to %+v", cached.Data[0])
+	}
+}
+
+func TestChecksumResultOtherTypes(t *testing.T) {
+	for _, result := range []any{int64(3), true, nil} {
+		if got := checksumResult(result); got != result {
+			t.Errorf("got %v for %v, want it unchanged", got, result)
+		}
+	}
+}
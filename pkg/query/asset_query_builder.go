@@ -2,58 +2,242 @@ package query
 
 import (
 	"asset-query/internal/response"
+	"asset-query/pkg/models"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
 )
 
 type Pagination[T any] struct {
-	Page       int    `json:"page"`              // Current page number
-	Limit      int    `json:"limit"`             // Number of items per page
-	TotalItems int64  `json:"totalItems"`        // Total number of items available
-	TotalPages int64  `json:"totalPages"`        // Total number of pages
-	Holders    *int64 `json:"holders,omitempty"` // Optional holder field
-	Data       []T    `json:"data"`              // The paginated items (can be any type)
+	Page             int    `json:"page"`                       // Current page number
+	Limit            int    `json:"limit"`                      // Number of items per page
+	TotalItems       int64  `json:"totalItems"`                 // Total number of items available
+	TotalPages       int64  `json:"totalPages"`                 // Total number of pages
+	ApproximateTotal bool   `json:"approximateTotal,omitempty"` // TotalItems and TotalPages are planner estimates, Holders is nil
+	Holders          *int64 `json:"holders,omitempty"`          // Distinct owners, nil when unknown such as with an approximate total
+	HasNext          bool   `json:"hasNext"`                    // Whether a next page exists
+	Data             []T    `json:"data"`                       // The paginated items (can be any type)
+}
+
+// paginatedResult is implemented by every Pagination instantiation
+type paginatedResult interface {
+	itemCount() int
+	copyData() any
+}
+
+func (p Pagination[T]) itemCount() int {
+	return len(p.Data)
+}
+
+// copyData returns p with its own copy of the Data slice
+func (p Pagination[T]) copyData() any {
+	p.Data = slices.Clone(p.Data)
+	return p
 }
 
 type assetQueryBuilderParam struct {
-	chainId       int32
-	collectionId  *string
-	tokenIds      *[]string
-	owner         *string
-	createdAtFrom *time.Time
-	createdAtTo   *time.Time
-	page          *int
-	limit         *int
-	offset        *int
-	config        *masterDbConfig
+	chainId          int32
+	collectionId     *string
+	collectionIds    []string
+	tokenIds         *[]string
+	owner            *string
+	owners           []string
+	withoutOwners    []string
+	withoutTokenIds  []string
+	includeBurned    bool
+	tokenIdFrom      string
+	tokenIdTo        string
+	order            AssetOrder
+	createdAtFrom    *time.Time
+	createdAtTo      *time.Time
+	updatedAtFrom    *time.Time
+	updatedAtTo      *time.Time
+	updatedBy        uuid.UUID
+	page             *int
+	limit            *int
+	offset           *int
+	filters          []Expr
+	fields           []string
+	withoutTotal     bool
+	approximateTotal bool
+	formattedBalance bool
+	minBalance       *string
+	maxBalance       *string
+	cacheTTL         *time.Duration
+	noCache          bool
+	err              error // First invalid argument, returned when the query runs
+	config           *masterDbConfig
 }
 
 func (b *assetQueryBuilderParam) getHttpClient() *HttpClient {
-	client := NewHttpClient(b.config.masterDbUrl)
-	return client
+	return b.config.httpClient
 }
 
 func NewAssetQueryBuilder(config *masterDbConfig) AssetQueryBuilder {
 	return &assetQueryBuilderParam{config: config}
 }
 
-// WithChainId implements AssetQueryBuilder.
-func (b *assetQueryBuilderParam) getCollectionType() masterDbCommon.CollectionType {
-	client := b.getHttpClient()
+// cached returns the value cached under key, or runs fetch once for all
+// concurrent callers of key and caches its result for ttl. A zero ttl skips
+// the cache but still coalesces.
+func (b *assetQueryBuilderParam) cached(ctx context.Context, key string, ttl time.Duration, tags []string, fetch func(ctx context.Context) (any, error)) (any, error) {
+	// Fetches started before an invalidation neither store their result nor
+	// are joined by later callers
+	generation := b.config.cacheGeneration.Load()
+	flightKey := fmt.Sprintf("%s@%d", key, generation)
+
+	cache := b.config.cache
+	if cache != nil && !b.noCache && ttl > 0 {
+		if value, ok := cache.Get(key); ok {
+			return value, nil
+		}
+
+		uncached := fetch
+		fetch = func(ctx context.Context) (any, error) {
+			value, err := uncached(ctx)
+			if err == nil && value != nil {
+				b.config.storeCached(generation, key, value, ttl, tags)
+			}
+			return value, err
+		}
+	}
+
+	if b.config.coalesce == nil {
+		return fetch(ctx)
+	}
+	return b.config.coalesce.do(ctx, flightKey, fetch)
+}
+
+// getCollection looks up a collection on the master. Concurrent lookups of
+// the same collection share one request, whatever the casing of its address.
+func (b *assetQueryBuilderParam) getCollection(ctx context.Context, chainId int32, collectionId string) (masterDbCommon.CollectionResponse, error) {
+	if normalized, err := normalizeCollectionId(collectionId); err == nil {
+		collectionId = normalized
+	}
 
-	var response response.HTTPResponse[masterDbCommon.CollectionResponse]
-	path := fmt.Sprintf("/chain/%d/collection/%s", b.chainId, *b.collectionId)
-	err := client.DoRequest(context.Background(), "GET", path, nil, &response)
+	lookup := func(ctx context.Context) (any, error) {
+		start := time.Now()
+		info := SpanInfo{
+			Operation:    OperationCollectionType,
+			ChainId:      chainId,
+			CollectionId: collectionId,
+			Source:       sourceMaster,
+		}
+		ctx, span := startSpan(ctx, b.config.instrumentation, info)
+
+		var response response.HTTPResponse[masterDbCommon.CollectionResponse]
+		path := fmt.Sprintf("/chain/%d/collection/%s", chainId, collectionId)
+		err := b.masterRequest(ctx, info, "GET", path, nil, &response)
+		span.end(SpanResult{Err: err})
+		if err != nil {
+			b.config.logger.ErrorContext(ctx, "collection lookup failed",
+				"chain", chainId,
+				"collection", collectionId,
+				"duration", time.Since(start),
+				"error", err,
+			)
+			return nil, err
+		}
+
+		b.config.logger.DebugContext(ctx, "collection resolved",
+			"chain", chainId,
+			"collection", collectionId,
+			"collectionType", response.Data.Type,
+			"decimals", response.Data.DecimalData,
+			"duration", time.Since(start),
+		)
+		return response.Data, nil
+	}
+
+	key := fmt.Sprintf("collection:%d:%s", chainId, collectionId)
+	tags := []string{chainCacheTag(chainId), collectionCacheTag(chainId, collectionId)}
+	collection, err := b.cached(ctx, key, b.config.cacheTTL, tags, lookup)
 	if err != nil {
-		fmt.Println("err", err)
-		return masterDbCommon.CollectionType("")
+		return masterDbCommon.CollectionResponse{}, err
+	}
+
+	return collection.(masterDbCommon.CollectionResponse), nil
+}
+
+// getSpecCollections looks up every collection of spec on the master, all at
+// once, in the order of spec.collectionIds(). The first failed lookup cancels
+// the others. A spec without collection ids is rejected before any request,
+// as its table cannot be resolved.
+func (b *assetQueryBuilderParam) getSpecCollections(ctx context.Context, spec QuerySpec) ([]masterDbCommon.CollectionResponse, error) {
+	collectionIds := spec.collectionIds()
+	if len(collectionIds) == 0 {
+		return nil, errors.New("collection id is required")
+	}
+
+	collections := make([]masterDbCommon.CollectionResponse, len(collectionIds))
+	if len(collectionIds) == 1 {
+		collection, err := b.getCollection(ctx, spec.ChainId, collectionIds[0])
+		if err != nil {
+			return nil, err
+		}
+		collections[0] = collection
+		return collections, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var failed sync.Once
+	var firstErr error
+	for i, collectionId := range collectionIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collection, err := b.getCollection(ctx, spec.ChainId, collectionId)
+			if err != nil {
+				failed.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			collections[i] = collection
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return collections, nil
+}
+
+// specCollectionType returns the collection type shared by the collections of
+// spec. Collections of different types live in different tables and cannot be
+// queried together.
+func specCollectionType(spec QuerySpec, collections []masterDbCommon.CollectionResponse) (masterDbCommon.CollectionType, error) {
+	collectionIds := spec.collectionIds()
+
+	var collectionType masterDbCommon.CollectionType
+	for i, collection := range collections {
+		if i > 0 && collection.Type != collectionType {
+			return masterDbCommon.CollectionType(""), fmt.Errorf("collections %s and %s have different types %s and %s",
+				collectionIds[0], collectionIds[i], collectionType, collection.Type)
+		}
+		collectionType = collection.Type
 	}
+	return collectionType, nil
+}
 
-	return response.Data.Type
+// setErr records the first invalid argument given to the builder
+func (b *assetQueryBuilderParam) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
 }
 
 // WithChainId implements AssetQueryBuilder.
@@ -62,8 +246,42 @@ func (b *assetQueryBuilderParam) WithChainId(chainId int32) AssetQueryBuilder {
 	return b
 }
 
-// WithCollectionId implements AssetQueryBuilder.
+// WithCollectionId implements AssetQueryBuilder. The address of the collection
+// id is validated and matched ignoring case.
 func (b *assetQueryBuilderParam) WithCollectionId(collectionId string) AssetQueryBuilder {
+	normalized, err := normalizeCollectionId(collectionId)
+	if err != nil {
+		b.setErr(fmt.Errorf("collection id: %w", err))
+		return b
+	}
+	b.collectionId = &normalized
+	return b
+}
+
+// WithCollectionIds restricts the query to assets of any of collectionIds,
+// in addition to the collection of WithCollectionId. The collections must
+// share one collection type.
+func (b *assetQueryBuilderParam) WithCollectionIds(collectionIds []string) AssetQueryBuilder {
+	for _, collectionId := range collectionIds {
+		normalized, err := normalizeCollectionId(collectionId)
+		if err != nil {
+			b.setErr(fmt.Errorf("collection id: %w", err))
+			return b
+		}
+		b.collectionIds = append(b.collectionIds, normalized)
+	}
+	return b
+}
+
+// WithCollection restricts the query to the collection ref. The chain of the
+// query must be the chain of ref, or the query fails with ErrChainMismatch.
+func (b *assetQueryBuilderParam) WithCollection(ref CollectionRef) AssetQueryBuilder {
+	if err := ref.Validate(); err != nil {
+		b.setErr(fmt.Errorf("collection: %w", err))
+		return b
+	}
+
+	collectionId := ref.String()
 	b.collectionId = &collectionId
 	return b
 }
@@ -80,15 +298,121 @@ func (b *assetQueryBuilderParam) WithCreatedAtTo(createdAtTo time.Time) AssetQue
 	return b
 }
 
+// WithUpdatedAtFrom restricts the query to assets updated at or after
+// updatedAtFrom. Such incremental sync queries always read fresh results and
+// bypass the cache.
+func (b *assetQueryBuilderParam) WithUpdatedAtFrom(updatedAtFrom time.Time) AssetQueryBuilder {
+	b.updatedAtFrom = &updatedAtFrom
+	return b
+}
+
+// WithUpdatedAtTo restricts the query to assets updated before updatedAtTo.
+// The bound is exclusive, so the windows [t0, t1) and [t1, t2) of consecutive
+// syncs neither skip nor repeat assets.
+func (b *assetQueryBuilderParam) WithUpdatedAtTo(updatedAtTo time.Time) AssetQueryBuilder {
+	b.updatedAtTo = &updatedAtTo
+	return b
+}
+
+// WithUpdatedBy restricts the query to assets last written by the updater updatedBy
+func (b *assetQueryBuilderParam) WithUpdatedBy(updatedBy uuid.UUID) AssetQueryBuilder {
+	b.updatedBy = updatedBy
+	return b
+}
+
 // WithLimit implements AssetQueryBuilder.
 func (b *assetQueryBuilderParam) WithLimit(limit int) AssetQueryBuilder {
 	b.limit = &limit
 	return b
 }
 
-// WithOwner implements AssetQueryBuilder.
+// WithOwner implements AssetQueryBuilder. The owner address is validated and
+// matched ignoring case.
 func (b *assetQueryBuilderParam) WithOwner(owner string) AssetQueryBuilder {
-	b.owner = &owner
+	normalized, err := normalizeAddress(owner)
+	if err != nil {
+		b.setErr(fmt.Errorf("owner: %w", err))
+		return b
+	}
+	b.owner = &normalized
+	return b
+}
+
+// WithOwners restricts the query to assets held by any of owners, in addition
+// to the owner of WithOwner
+func (b *assetQueryBuilderParam) WithOwners(owners []string) AssetQueryBuilder {
+	for _, owner := range owners {
+		normalized, err := normalizeAddress(owner)
+		if err != nil {
+			b.setErr(fmt.Errorf("owner: %w", err))
+			return b
+		}
+		b.owners = append(b.owners, normalized)
+	}
+	return b
+}
+
+// WithoutOwners excludes assets held by any of owners
+func (b *assetQueryBuilderParam) WithoutOwners(owners []string) AssetQueryBuilder {
+	for _, owner := range owners {
+		normalized, err := normalizeAddress(owner)
+		if err != nil {
+			b.setErr(fmt.Errorf("excluded owner: %w", err))
+			return b
+		}
+		b.withoutOwners = append(b.withoutOwners, normalized)
+	}
+	return b
+}
+
+// WithoutTokenIds excludes assets with any of tokenIds
+func (b *assetQueryBuilderParam) WithoutTokenIds(tokenIds []string) AssetQueryBuilder {
+	b.withoutTokenIds = append(b.withoutTokenIds, tokenIds...)
+	return b
+}
+
+// WithTokenIdRange restricts the query to token ids from from to to
+// inclusive, compared as unsigned integers. An empty bound is open.
+func (b *assetQueryBuilderParam) WithTokenIdRange(from string, to string) AssetQueryBuilder {
+	var bounds [2]models.Uint256
+	for i, bound := range []string{from, to} {
+		if bound == "" {
+			continue
+		}
+		parsed, err := models.ParseUint256(bound)
+		if err != nil {
+			b.setErr(fmt.Errorf("token id range: %w", err))
+			return b
+		}
+		bounds[i] = parsed
+	}
+	if from != "" && to != "" && bounds[0].Big().Cmp(bounds[1].Big()) > 0 {
+		b.setErr(fmt.Errorf("token id range: from %s is greater than to %s", from, to))
+		return b
+	}
+
+	// Canonical forms, so "007" and "7" build the same query
+	b.tokenIdFrom, b.tokenIdTo = "", ""
+	if from != "" {
+		b.tokenIdFrom = bounds[0].String()
+	}
+	if to != "" {
+		b.tokenIdTo = bounds[1].String()
+	}
+	return b
+}
+
+// WithOrder sets the order of the assets in a page. Without it assets are
+// ordered by creation time, then id.
+func (b *assetQueryBuilderParam) WithOrder(order AssetOrder) AssetQueryBuilder {
+	b.order = order
+	return b
+}
+
+// WithIncludeBurned keeps the assets of the owners excluded by the config
+// WithExcludedOwners in this query
+func (b *assetQueryBuilderParam) WithIncludeBurned() AssetQueryBuilder {
+	b.includeBurned = true
 	return b
 }
 
@@ -104,27 +428,127 @@ func (b *assetQueryBuilderParam) WithTokenIds(tokenIds []string) AssetQueryBuild
 	return b
 }
 
+// WithFilter restricts the query to assets matching expr. Repeated calls are
+// combined with AND, as are the other With filters.
+func (b *assetQueryBuilderParam) WithFilter(expr Expr) AssetQueryBuilder {
+	b.filters = append(b.filters, expr)
+	return b
+}
+
+// WithFields selects only the given columns, such as "token_id" and "owner".
+// Fields that are not selected are left zero-valued in the results.
+func (b *assetQueryBuilderParam) WithFields(fields ...string) AssetQueryBuilder {
+	b.fields = append(b.fields, fields...)
+	return b
+}
+
+// WithoutTotal skips counting the matching assets. TotalItems and TotalPages
+// are left zero and HasNext is found by fetching one row past the page.
+func (b *assetQueryBuilderParam) WithoutTotal() AssetQueryBuilder {
+	b.withoutTotal = true
+	return b
+}
+
+// WithApproximateTotal lets large totals come from the row estimate of the
+// query plan instead of an exact count. Estimates below the config threshold
+// are counted exactly. Holders are not counted when the total is approximate,
+// so Pagination.Holders is nil.
+func (b *assetQueryBuilderParam) WithApproximateTotal() AssetQueryBuilder {
+	b.approximateTotal = true
+	return b
+}
+
+// WithFormattedBalance fills in the decimals and formatted balance of ERC20
+// results from the decimals of their collection
+func (b *assetQueryBuilderParam) WithFormattedBalance() AssetQueryBuilder {
+	b.formattedBalance = true
+	return b
+}
+
+// WithMinBalance restricts the query to assets holding at least amount, a
+// decimal such as "1.5" in units of the collection decimals
+func (b *assetQueryBuilderParam) WithMinBalance(amount string) AssetQueryBuilder {
+	b.minBalance = &amount
+	return b
+}
+
+// WithMaxBalance restricts the query to assets holding at most amount, a
+// decimal such as "1.5" in units of the collection decimals
+func (b *assetQueryBuilderParam) WithMaxBalance(amount string) AssetQueryBuilder {
+	b.maxBalance = &amount
+	return b
+}
+
+// WithCacheTTL caches the result of this query for ttl instead of the config
+// default. The ttl must be positive and the config must have a cache.
+func (b *assetQueryBuilderParam) WithCacheTTL(ttl time.Duration) AssetQueryBuilder {
+	if ttl <= 0 {
+		b.setErr(errors.New("cache ttl must be positive"))
+		return b
+	}
+	if b.config.cache == nil {
+		b.setErr(errors.New("cache ttl requires a config created WithCache"))
+		return b
+	}
+	b.cacheTTL = &ttl
+	return b
+}
+
+// WithNoCache bypasses the result cache for this query
+func (b *assetQueryBuilderParam) WithNoCache() AssetQueryBuilder {
+	b.noCache = true
+	return b
+}
+
 type AssetQueryFunction interface {
 	GetAssetQueryBuilder() (*assetQueryBuilderParam, error)
 	GetPaginatedAsset() (any, error)
+	GetPaginatedAssetContext(ctx context.Context) (any, error)
+	Count(ctx context.Context) (int64, error)
+	Exists(ctx context.Context) (bool, error)
+	ToSQL() (SQLStatements, error)
+	ToSQLContext(ctx context.Context) (SQLStatements, error)
+	ToRequest() ([]byte, error)
+	ToRequestContext(ctx context.Context) ([]byte, error)
+	Explain(ctx context.Context) (json.RawMessage, error)
 }
 
 type AssetQueryBuilder interface {
 	WithChainId(chainId int32) AssetQueryBuilder
 	WithCollectionId(collectionId string) AssetQueryBuilder
+	WithCollectionIds(collectionIds []string) AssetQueryBuilder
+	WithCollection(ref CollectionRef) AssetQueryBuilder
 	WithTokenIds(tokenIds []string) AssetQueryBuilder
 	WithOwner(owner string) AssetQueryBuilder
+	WithOwners(owners []string) AssetQueryBuilder
+	WithoutOwners(owners []string) AssetQueryBuilder
+	WithoutTokenIds(tokenIds []string) AssetQueryBuilder
+	WithIncludeBurned() AssetQueryBuilder
+	WithTokenIdRange(from string, to string) AssetQueryBuilder
+	WithOrder(order AssetOrder) AssetQueryBuilder
 	WithCreatedAtFrom(createdAtFrom time.Time) AssetQueryBuilder
 	WithCreatedAtTo(createdAtTo time.Time) AssetQueryBuilder
+	WithUpdatedAtFrom(updatedAtFrom time.Time) AssetQueryBuilder
+	WithUpdatedAtTo(updatedAtTo time.Time) AssetQueryBuilder
+	WithUpdatedBy(updatedBy uuid.UUID) AssetQueryBuilder
 	WithPage(page int) AssetQueryBuilder
 	WithLimit(limit int) AssetQueryBuilder
+	WithFilter(expr Expr) AssetQueryBuilder
+	WithFields(fields ...string) AssetQueryBuilder
+	WithoutTotal() AssetQueryBuilder
+	WithApproximateTotal() AssetQueryBuilder
+	WithFormattedBalance() AssetQueryBuilder
+	WithMinBalance(amount string) AssetQueryBuilder
+	WithMaxBalance(amount string) AssetQueryBuilder
+	WithCacheTTL(ttl time.Duration) AssetQueryBuilder
+	WithNoCache() AssetQueryBuilder
 	Build() AssetQueryFunction
 }
 
 func (b *assetQueryBuilderParam) Build() AssetQueryFunction {
 	// Set default values if not provided
 	defaultPage := 1
-	defaultLimit := 10
+	defaultLimit := b.config.defaultLimit
 
 	if b.page != nil {
 		if *b.page < 1 {
@@ -134,7 +558,7 @@ func (b *assetQueryBuilderParam) Build() AssetQueryFunction {
 	}
 
 	if b.limit != nil {
-		if *b.limit > 100 {
+		if *b.limit < 1 || *b.limit > b.config.maxLimit {
 			return nil
 		}
 		defaultLimit = *b.limit
@@ -142,6 +566,8 @@ func (b *assetQueryBuilderParam) Build() AssetQueryFunction {
 
 	// Calculate offset
 	offset := (defaultPage - 1) * defaultLimit
+	b.page = &defaultPage
+	b.limit = &defaultLimit
 	b.offset = &offset
 	return b
 }
@@ -153,143 +579,411 @@ func (b *assetQueryBuilderParam) GetAssetQueryBuilder() (*assetQueryBuilderParam
 	return b, nil
 }
 
-func (b *assetQueryBuilderParam) getLocalAssetQuery() (any, error) {
-	collectionType := b.getCollectionType()
-	fmt.Println("collectionType", collectionType)
+func (b *assetQueryBuilderParam) getLocalAssetQuery(ctx context.Context, spec QuerySpec) (any, error) {
+	collections, err := b.getSpecCollections(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err = resolveBalanceFilters(spec, collections)
+	if err != nil {
+		return nil, err
+	}
+
+	collectionType, err := specCollectionType(spec, collections)
+	if err != nil {
+		return nil, err
+	}
+
+	tableName, ok := collectionTables[collectionType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported collection type %q", collectionType)
+	}
 
-	filterConditions := b.getFilterConditions()
+	switch spec.Mode {
+	case QueryModeCount:
+		return queryLocalCount(ctx, b.config, spec, tableName)
+	case QueryModeExists:
+		return queryLocalExists(ctx, b.config, spec, tableName)
+	}
 
 	switch collectionType {
 	case masterDbCommon.CollectionTypeERC721:
-		totalAssets, _, err := CountItemsWithFilter(b.config.localDb, "erc_721_collection_assets", filterConditions)
+		return queryLocalPage[models.Erc721CollectionAsset](ctx, b.config, spec, tableName)
+	case masterDbCommon.CollectionTypeERC1155:
+		return queryLocalPage[models.Erc1155CollectionAsset](ctx, b.config, spec, tableName)
+	case masterDbCommon.CollectionTypeERC20:
+		page, err := queryLocalPage[models.Erc20CollectionAsset](ctx, b.config, spec, tableName)
 		if err != nil {
 			return nil, err
 		}
+		return b.formatBalances(ctx, spec, collections, page)
+	}
 
-		assets, _ := QueryWithDynamicFilter[masterDbCommon.Erc721CollectionAssetResponse](b.config.localDb, "erc_721_collection_assets", *b.limit, *b.offset, filterConditions)
-		return Pagination[masterDbCommon.Erc721CollectionAssetResponse]{
-			Page:       *b.page,
-			Limit:      *b.limit,
-			TotalItems: int64(totalAssets),
-			TotalPages: (int64(totalAssets) + int64(*b.limit) - 1) / int64(*b.limit),
-			Data:       assets,
-		}, nil
+	return nil, fmt.Errorf("unsupported collection type %q", collectionType)
+}
 
-	case masterDbCommon.CollectionTypeERC1155:
-		totalAssets, _, err := CountItemsWithFilter(b.config.localDb, "erc_1155_collection_assets", filterConditions)
+// queryLocalCount counts the assets of tableName matching spec
+func queryLocalCount(ctx context.Context, config *masterDbConfig, spec QuerySpec, tableName string) (int64, error) {
+	statements, err := localStatements(spec, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	return queryLocalCountStatement(ctx, config, config.localDb, spec, statements.Count)
+}
+
+// queryLocalExists checks whether any asset of tableName matches spec
+func queryLocalExists(ctx context.Context, config *masterDbConfig, spec QuerySpec, tableName string) (bool, error) {
+	statements, err := localStatements(spec, tableName)
+	if err != nil {
+		return false, err
+	}
+
+	exists := statements.Exists
+	ctx, span := startSpan(ctx, config.instrumentation, spec.spanInfo(OperationExists, sourceLocal, exists.SQL))
+	found, err := queryExists(ctx, config.localDb, exists.SQL, exists.Args)
+	rows := int64(0)
+	if found {
+		rows = 1
+	}
+	span.end(SpanResult{Rows: rows, Err: err})
+	return found, err
+}
+
+// queryLocalPage counts the assets of tableName matching spec and their
+// holders, and fetches the requested page. The statements run in one
+// REPEATABLE READ read-only transaction, so the totals always agree with the
+// page. Without total only the page statement runs, outside a transaction.
+func queryLocalPage[T any](ctx context.Context, config *masterDbConfig, spec QuerySpec, tableName string) (Pagination[T], error) {
+	statements, err := localStatements(spec, tableName)
+	if err != nil {
+		return Pagination[T]{}, err
+	}
+
+	if spec.WithoutTotal {
+		assets, err := queryLocalRows[T](ctx, config, config.localDb, spec, statements.Data)
 		if err != nil {
-			return nil, err
+			return Pagination[T]{}, err
 		}
 
-		assets, _ := QueryWithDynamicFilter[masterDbCommon.Erc1155CollectionAssetResponse](b.config.localDb, "erc_1155_collection_assets", *b.limit, *b.offset, filterConditions)
-		return Pagination[masterDbCommon.Erc1155CollectionAssetResponse]{
-			Page:       *b.page,
-			Limit:      *b.limit,
-			TotalItems: int64(totalAssets),
-			TotalPages: (int64(totalAssets) + int64(*b.limit) - 1) / int64(*b.limit),
-			Data:       assets,
+		hasNext := len(assets) > spec.Limit
+		if hasNext {
+			assets = assets[:spec.Limit]
+		}
+		return Pagination[T]{
+			Page:    spec.Page,
+			Limit:   spec.Limit,
+			HasNext: hasNext,
+			Data:    assets,
 		}, nil
+	}
 
-	case masterDbCommon.CollectionTypeERC20:
-		totalAssets, _, err := CountItemsWithFilter(b.config.localDb, "erc_20_collection_assets", filterConditions)
+	tx, err := config.localDb.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Pagination[T]{}, fmt.Errorf("error starting snapshot transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var totalAssets int64
+	var holders *int64
+	approximate := false
+	if spec.ApproximateTotal {
+		estimate, err := queryLocalEstimate(ctx, config, tx, spec, statements)
 		if err != nil {
-			return nil, err
+			return Pagination[T]{}, err
 		}
+		if estimate >= 0 && estimate >= config.approximateCountThreshold {
+			totalAssets = estimate
+			approximate = true
+		}
+	}
 
-		assets, _ := QueryWithDynamicFilter[masterDbCommon.Erc20CollectionAssetResponse](b.config.localDb, "erc_20_collection_assets", *b.limit, *b.offset, filterConditions)
-		return Pagination[masterDbCommon.Erc20CollectionAssetResponse]{
-			Page:       *b.page,
-			Limit:      *b.limit,
-			TotalItems: int64(totalAssets),
-			TotalPages: (int64(totalAssets) + int64(*b.limit) - 1) / int64(*b.limit),
-			Data:       assets,
-		}, nil
+	if !approximate {
+		totalAssets, err = queryLocalCountStatement(ctx, config, tx, spec, statements.Count)
+		if err != nil {
+			return Pagination[T]{}, err
+		}
+
+		holderCount, err := queryLocalCountStatement(ctx, config, tx, spec, statements.Holders)
+		if err != nil {
+			return Pagination[T]{}, err
+		}
+		holders = &holderCount
 	}
 
-	return nil, nil
+	assets, err := queryLocalRows[T](ctx, config, tx, spec, statements.Data)
+	if err != nil {
+		return Pagination[T]{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Pagination[T]{}, fmt.Errorf("error committing snapshot transaction: %w", err)
+	}
+
+	totalPages := (totalAssets + int64(spec.Limit) - 1) / int64(spec.Limit)
+	return Pagination[T]{
+		Page:             spec.Page,
+		Limit:            spec.Limit,
+		TotalItems:       totalAssets,
+		TotalPages:       totalPages,
+		ApproximateTotal: approximate,
+		Holders:          holders,
+		HasNext:          int64(spec.Page) < totalPages,
+		Data:             assets,
+	}, nil
 }
 
-func (b *assetQueryBuilderParam) getMasterDbAsset() (any, error) {
-	httpClient := b.getHttpClient()
-	collectionType := b.getCollectionType()
-	fmt.Println("collectionType", collectionType)
+// queryLocalEstimate runs the estimate statement inside a count span
+func queryLocalEstimate(ctx context.Context, config *masterDbConfig, db queryer, spec QuerySpec, statements SQLStatements) (int64, error) {
+	statement := statements.Estimate
+	ctx, span := startSpan(ctx, config.instrumentation, spec.spanInfo(OperationCount, sourceLocal, statement.SQL))
+	estimate, err := queryEstimate(ctx, db, statement.SQL, statement.Args)
+	span.end(SpanResult{Rows: estimate, Err: err})
+	return estimate, err
+}
 
-	requestBody := map[string]interface{}{
-		"chainId":       b.chainId,
-		"collectionId":  b.collectionId,
-		"tokenIds":      b.tokenIds,
-		"owner":         b.owner,
-		"createdAtFrom": b.createdAtFrom,
-		"createdAtTo":   b.createdAtTo,
-		"page":          b.page,
-		"limit":         b.limit,
-		"offset":        b.offset,
-	}
+// queryLocalCountStatement runs a count statement inside a count span
+func queryLocalCountStatement(ctx context.Context, config *masterDbConfig, db queryer, spec QuerySpec, statement Statement) (int64, error) {
+	ctx, span := startSpan(ctx, config.instrumentation, spec.spanInfo(OperationCount, sourceLocal, statement.SQL))
+	count, err := queryCount(ctx, db, statement.SQL, statement.Args)
+	span.end(SpanResult{Rows: count, Err: err})
+	return count, err
+}
 
-	switch collectionType {
-	case masterDbCommon.CollectionTypeERC721:
-		var response response.HTTPResponse[Pagination[masterDbCommon.Erc721CollectionAssetResponse]]
-		err := httpClient.DoRequest(context.Background(), "POST", "/query-builder", requestBody, &response)
+// queryLocalRows runs the data statement inside a data span
+func queryLocalRows[T any](ctx context.Context, config *masterDbConfig, db queryer, spec QuerySpec, statement Statement) ([]T, error) {
+	ctx, span := startSpan(ctx, config.instrumentation, spec.spanInfo(OperationData, sourceLocal, statement.SQL))
+	rows, err := queryRows[T](ctx, db, statement.SQL, statement.Args)
+	span.end(SpanResult{Rows: int64(len(rows)), Err: err})
+	return rows, err
+}
+
+// masterRequest sends a request to the master inside a master_request span
+func (b *assetQueryBuilderParam) masterRequest(ctx context.Context, info SpanInfo, method string, path string, body interface{}, response interface{}) error {
+	info.Operation = OperationMasterRequest
+	info.Source = sourceMaster
+	info.Method = method
+	info.Path = path
+
+	ctx, span := startSpan(ctx, b.config.instrumentation, info)
+	status, err := b.getHttpClient().doRequest(ctx, method, path, body, response)
+	span.end(SpanResult{HttpStatus: status, Err: err})
+	return err
+}
+
+func (b *assetQueryBuilderParam) getMasterDbAsset(ctx context.Context, spec QuerySpec) (any, error) {
+	// Counts and exists checks only need the collections for balance bounds
+	var collections []masterDbCommon.CollectionResponse
+	if spec.Mode == QueryModePage || spec.MinBalance != "" || spec.MaxBalance != "" {
+		var err error
+		collections, err = b.getSpecCollections(ctx, spec)
 		if err != nil {
-			fmt.Println("err", err)
 			return nil, err
 		}
-		fmt.Println("response", response.Data)
-		return response.Data, nil
+	}
+
+	spec, err := resolveBalanceFilters(spec, collections)
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.Mode {
+	case QueryModeCount:
+		return queryMasterCount(ctx, b, spec)
+	case QueryModeExists:
+		return queryMasterExists(ctx, b, spec)
+	}
+
+	collectionType, err := specCollectionType(spec, collections)
+	if err != nil {
+		return nil, err
+	}
+
+	switch collectionType {
+	case masterDbCommon.CollectionTypeERC721:
+		return queryMasterPage[models.Erc721CollectionAsset](ctx, b, spec)
 	case masterDbCommon.CollectionTypeERC1155:
-		var response response.HTTPResponse[Pagination[masterDbCommon.Erc1155CollectionAssetResponse]]
-		err := httpClient.DoRequest(context.Background(), "POST", "/query-builder", requestBody, &response)
-		if err != nil {
-			fmt.Println("err", err)
-			return nil, err
-		}
-		fmt.Println("response", response.Data)
-		return response.Data, nil
+		return queryMasterPage[models.Erc1155CollectionAsset](ctx, b, spec)
 	case masterDbCommon.CollectionTypeERC20:
-		var response response.HTTPResponse[Pagination[masterDbCommon.Erc20CollectionAssetResponse]]
-		err := httpClient.DoRequest(context.Background(), "POST", "/query-builder", requestBody, &response)
+		page, err := queryMasterPage[models.Erc20CollectionAsset](ctx, b, spec)
 		if err != nil {
-			fmt.Println("err", err)
 			return nil, err
 		}
-		fmt.Println("response", response.Data)
-		return response.Data, nil
+		return b.formatBalances(ctx, spec, collections, page)
+	}
+
+	return nil, fmt.Errorf("unsupported collection type %q", collectionType)
+}
+
+// queryMasterPage fetches the page of spec from the master /query-builder
+// endpoint, in several requests when spec has more token ids than one carries
+func queryMasterPage[T any](ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec) (Pagination[T], error) {
+	if chunks := tokenIdChunks(spec.TokenIds, b.config.tokenIdChunkSize); chunks != nil {
+		return queryMasterChunkedPage[T](ctx, b, spec, chunks)
+	}
+
+	var response response.HTTPResponse[Pagination[T]]
+	err := b.masterRequest(ctx, spec.spanInfo(OperationMasterRequest, sourceMaster, ""), "POST", "/query-builder", spec.requestBody(), &response)
+	if err != nil {
+		return Pagination[T]{}, err
+	}
+
+	page := response.Data
+	if !spec.WithoutTotal {
+		page.HasNext = int64(page.Page) < page.TotalPages
+	}
+	return page, nil
+}
+
+// queryMasterCount reads the number of assets matching spec from the totals of
+// a single item master page, which does not need the collection type
+func queryMasterCount(ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec) (int64, error) {
+	if chunks := tokenIdChunks(spec.TokenIds, b.config.tokenIdChunkSize); chunks != nil {
+		return queryMasterChunkedCount(ctx, b, spec, chunks)
+	}
+
+	spec.Page, spec.Limit, spec.Offset = 1, 1, 0
+
+	var response response.HTTPResponse[Pagination[json.RawMessage]]
+	err := b.masterRequest(ctx, spec.spanInfo(OperationMasterRequest, sourceMaster, ""), "POST", "/query-builder", spec.requestBody(), &response)
+	if err != nil {
+		return 0, err
 	}
+	return response.Data.TotalItems, nil
+}
+
+// queryMasterExists checks whether the master returns any asset matching spec
+func queryMasterExists(ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec) (bool, error) {
+	if chunks := tokenIdChunks(spec.TokenIds, b.config.tokenIdChunkSize); chunks != nil {
+		return queryMasterChunkedExists(ctx, b, spec, chunks)
+	}
+
+	spec.Page, spec.Limit, spec.Offset = 1, 1, 0
+	spec.WithoutTotal = true
 
-	return nil, nil
+	var response response.HTTPResponse[Pagination[json.RawMessage]]
+	err := b.masterRequest(ctx, spec.spanInfo(OperationMasterRequest, sourceMaster, ""), "POST", "/query-builder", spec.requestBody(), &response)
+	if err != nil {
+		return false, err
+	}
+	return len(response.Data.Data) > 0 || response.Data.TotalItems > 0, nil
 }
 
+// GetPaginatedAsset returns a page of the assets of the collection. The page
+// is a Pagination of models.Erc721CollectionAsset, models.Erc1155CollectionAsset
+// or models.Erc20CollectionAsset from asset-query/pkg/models, depending on the
+// collection type. Pages used to hold the masterdb *CollectionAssetResponse
+// types, so type assertions on the result must use the models types instead.
 func (b *assetQueryBuilderParam) GetPaginatedAsset() (any, error) {
+	return b.GetPaginatedAssetContext(context.Background())
+}
+
+// GetPaginatedAssetContext is like GetPaginatedAsset but stops waiting on the
+// master, including time spent throttled, once ctx is done.
+func (b *assetQueryBuilderParam) GetPaginatedAssetContext(ctx context.Context) (any, error) {
+	spec, err := b.validSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	handler := chainMiddleware(b.config.middlewares, b.execute)
+	return handler(ctx, spec)
+}
 
-	if !b.config.useMasterDb {
-		return b.getLocalAssetQuery()
+// Count returns the number of assets matching the query, ignoring pagination
+func (b *assetQueryBuilderParam) Count(ctx context.Context) (int64, error) {
+	spec, err := b.validSpec()
+	if err != nil {
+		return 0, err
 	}
-	return b.getMasterDbAsset()
+	spec.Mode = QueryModeCount
 
+	result, err := chainMiddleware(b.config.middlewares, b.execute)(ctx, spec)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("count query returned %T, not int64", result)
+	}
+	return count, nil
 }
 
-func (b *assetQueryBuilderParam) getFilterConditions() map[string][]string {
-	filterConditions := make(map[string][]string)
+// Exists reports whether any asset matches the query, without counting them
+func (b *assetQueryBuilderParam) Exists(ctx context.Context) (bool, error) {
+	spec, err := b.validSpec()
+	if err != nil {
+		return false, err
+	}
+	spec.Mode = QueryModeExists
 
-	if b.collectionId != nil {
-		filterConditions["collection_id"] = []string{*b.collectionId}
+	result, err := chainMiddleware(b.config.middlewares, b.execute)(ctx, spec)
+	if err != nil {
+		return false, err
+	}
+	exists, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("exists query returned %T, not bool", result)
 	}
+	return exists, nil
+}
 
-	if b.tokenIds != nil && len(*b.tokenIds) > 0 {
-		filterConditions["token_id"] = *b.tokenIds
+// execute runs spec after the middleware chain. Concurrent identical queries
+// share one execution, whose result is also served from the config cache when
+// one is set. Every caller gets its own copy of the Data slice of a page, so
+// reordering or replacing assets never affects other callers.
+func (b *assetQueryBuilderParam) execute(ctx context.Context, spec QuerySpec) (any, error) {
+	source := sourceLocal
+	query := b.getLocalAssetQuery
+	if b.config.useMasterDb {
+		source = sourceMaster
+		query = b.getMasterDbAsset
 	}
 
-	if b.owner != nil {
-		filterConditions["owner"] = []string{*b.owner}
+	run := func(ctx context.Context) (any, error) {
+		start := time.Now()
+		result, err := query(ctx, spec)
+
+		attrs := []any{
+			"chain", spec.ChainId,
+			"collection", spec.CollectionId,
+			"source", source,
+			"duration", time.Since(start),
+		}
+		if err != nil {
+			b.config.logger.ErrorContext(ctx, "asset query failed", append(attrs, "error", err)...)
+			return nil, err
+		}
+
+		if b.config.checksumAddresses {
+			result = checksumResult(result)
+		}
+
+		rows := 0
+		if page, ok := result.(paginatedResult); ok {
+			rows = page.itemCount()
+		}
+		b.config.logger.DebugContext(ctx, "asset query executed", append(attrs, "rows", rows)...)
+		return result, nil
 	}
 
-	if b.createdAtFrom != nil {
-		filterConditions["created_at_from"] = []string{b.createdAtFrom.Format(time.RFC3339)}
+	ttl := b.config.cacheTTL
+	if b.cacheTTL != nil {
+		ttl = *b.cacheTTL
+	}
+	if !spec.UpdatedAtFrom.IsZero() {
+		ttl = 0
 	}
 
-	if b.createdAtTo != nil {
-		filterConditions["created_at_to"] = []string{b.createdAtTo.Format(time.RFC3339)}
+	tags := []string{chainCacheTag(spec.ChainId)}
+	for _, collectionId := range spec.collectionIds() {
+		tags = append(tags, collectionCacheTag(spec.ChainId, collectionId))
 	}
 
-	return filterConditions
+	result, err := b.cached(ctx, "query:"+spec.fingerprint(source), ttl, tags, run)
+	if page, ok := result.(paginatedResult); ok {
+		result = page.copyData()
+	}
+	return result, err
 }
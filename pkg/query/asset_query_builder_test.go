@@ -0,0 +1,108 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"asset-query/internal/response"
+
+	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
+)
+
+func TestGetSpecCollections(t *testing.T) {
+	const otherCollection = "1:0x0000000000000000000000000000000000000001"
+
+	tests := []struct {
+		name         string
+		spec         QuerySpec
+		wantAddress  []string
+		wantErr      string
+		wantRequests int32
+	}{
+		{"no collection", QuerySpec{ChainId: 1}, nil, "collection id is required", 0},
+		{"one collection", QuerySpec{ChainId: 1, CollectionId: "1:" + collectionAddress}, []string{collectionAddress}, "", 1},
+		{
+			"several collections in order",
+			QuerySpec{ChainId: 1, CollectionIds: []string{otherCollection, "1:" + collectionAddress}},
+			[]string{"0x0000000000000000000000000000000000000001", collectionAddress},
+			"", 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				_, address, _ := strings.Cut(path.Base(r.URL.Path), ":")
+				collection := masterDbCommon.CollectionResponse{
+					ChainID:           1,
+					CollectionAddress: address,
+					Type:              masterDbCommon.CollectionTypeERC721,
+				}
+				json.NewEncoder(w).Encode(response.HTTPResponse[masterDbCommon.CollectionResponse]{Data: collection})
+			}))
+			defer server.Close()
+
+			config, err := NewMasterDbConfig(nil, server.URL, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &assetQueryBuilderParam{config: config}
+
+			collections, err := b.getSpecCollections(context.Background(), test.spec)
+			switch {
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, test.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				if len(collections) != len(test.wantAddress) {
+					t.Fatalf("got %d collections, want %d", len(collections), len(test.wantAddress))
+				}
+				for i, collection := range collections {
+					if collection.CollectionAddress != test.wantAddress[i] {
+						t.Errorf("collection %d is %s, want %s", i, collection.CollectionAddress, test.wantAddress[i])
+					}
+				}
+			}
+
+			if got := requests.Load(); got != test.wantRequests {
+				t.Errorf("got %d master requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestQueryWithoutCollectionSendsNoRequest(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	config, err := NewMasterDbConfig(nil, server.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := config.CreateQueryBuilder().WithChainId(1).Build()
+
+	if _, err := query.GetPaginatedAsset(); err == nil || !strings.Contains(err.Error(), "collection id is required") {
+		t.Errorf("got error %v, want the collection id to be required", err)
+	}
+	if _, err := query.ToSQL(); err == nil || !strings.Contains(err.Error(), "collection id is required") {
+		t.Errorf("ToSQL got error %v, want the collection id to be required", err)
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("got %d master requests, want none", got)
+	}
+}
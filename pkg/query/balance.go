@@ -0,0 +1,122 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"asset-query/pkg/models"
+
+	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
+)
+
+// resolveBalanceFilters converts the human-readable balance bounds of spec to
+// raw balance filters using the decimals of its collections, as returned by
+// getSpecCollections, so the local and master queries only ever compare raw
+// integer balances, numerically rather than as text.
+func resolveBalanceFilters(spec QuerySpec, collections []masterDbCommon.CollectionResponse) (QuerySpec, error) {
+	if spec.MinBalance == "" && spec.MaxBalance == "" {
+		return spec, nil
+	}
+
+	if len(spec.collectionIds()) == 0 {
+		return QuerySpec{}, errors.New("balance filters require a collection id")
+	}
+
+	decimals := -1
+	for _, collection := range collections {
+		if decimals >= 0 && collection.DecimalData != decimals {
+			return QuerySpec{}, errors.New("balance filters require collections with the same decimals")
+		}
+		decimals = collection.DecimalData
+	}
+
+	var where AndExpr
+	if spec.Filter != nil {
+		where = append(where, spec.Filter)
+	}
+
+	bounds := []struct {
+		amount string
+		op     FilterOp
+	}{
+		{spec.MinBalance, FilterGte},
+		{spec.MaxBalance, FilterLte},
+	}
+	for _, bound := range bounds {
+		if bound.amount == "" {
+			continue
+		}
+		raw, err := models.ParseUnits(bound.amount, decimals)
+		if err != nil {
+			return QuerySpec{}, fmt.Errorf("%w: balance: %v", ErrInvalidFilter, err)
+		}
+		where = append(where, Filter{Column: "balance", Op: bound.op, Values: []string{raw.String()}, Numeric: true})
+	}
+
+	spec.Filter = where
+	spec.MinBalance, spec.MaxBalance = "", ""
+	return spec, nil
+}
+
+// formatBalances fills in the decimals and formatted balance of every asset of
+// page from its collection when spec asks for formatted balances. Assets whose
+// collection id was not selected use the collection of spec, if it has one.
+// The collections of spec are reused; other collections are looked up.
+func (b *assetQueryBuilderParam) formatBalances(ctx context.Context, spec QuerySpec, collections []masterDbCommon.CollectionResponse, page Pagination[models.Erc20CollectionAsset]) (Pagination[models.Erc20CollectionAsset], error) {
+	if !spec.FormattedBalance {
+		return page, nil
+	}
+
+	type collectionKey struct {
+		chainId      int32
+		collectionId string
+	}
+	decimalsOf := make(map[collectionKey]int)
+
+	collectionIds := spec.collectionIds()
+	if len(collections) == len(collectionIds) {
+		for i, collectionId := range collectionIds {
+			decimalsOf[collectionKey{spec.ChainId, collectionId}] = collections[i].DecimalData
+		}
+	}
+
+	var specCollectionId string
+	if len(collectionIds) == 1 {
+		specCollectionId = collectionIds[0]
+	}
+
+	page.Data = slices.Clone(page.Data)
+	for i := range page.Data {
+		asset := &page.Data[i]
+
+		key := collectionKey{asset.ChainID, asset.CollectionID}
+		if key.chainId == 0 {
+			key.chainId = spec.ChainId
+		}
+		if key.collectionId == "" {
+			key.collectionId = specCollectionId
+		}
+		if key.collectionId == "" {
+			continue
+		}
+		if normalized, err := normalizeCollectionId(key.collectionId); err == nil {
+			key.collectionId = normalized
+		}
+
+		decimals, ok := decimalsOf[key]
+		if !ok {
+			collection, err := b.getCollection(ctx, key.chainId, key.collectionId)
+			if err != nil {
+				return page, err
+			}
+			decimals = collection.DecimalData
+			decimalsOf[key] = decimals
+		}
+
+		asset.Decimals = &decimals
+		asset.FormattedBalance = asset.Balance.Format(decimals)
+	}
+	return page, nil
+}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"asset-query/internal/response"
+
+	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
+)
+
+// schemaDSNEnv names the environment variable holding the DSN of a Postgres
+// database with the asset tables of the crawler schema. The schema tests are
+// skipped when it is unset. They only read, in transactions rolled back.
+const schemaDSNEnv = "ASSET_QUERY_SCHEMA_DSN"
+
+func TestResolveBalanceFilters(t *testing.T) {
+	erc20 := func(decimals int) masterDbCommon.CollectionResponse {
+		return masterDbCommon.CollectionResponse{Type: masterDbCommon.CollectionTypeERC20, DecimalData: decimals}
+	}
+
+	tests := []struct {
+		name        string
+		spec        QuerySpec
+		collections []masterDbCommon.CollectionResponse
+		wantSQL     string
+		wantArgs    []interface{}
+		wantErr     bool
+	}{
+		{
+			"no bounds",
+			QuerySpec{CollectionId: inspectCollectionId},
+			nil, "", nil, false,
+		},
+		{
+			"min and max",
+			QuerySpec{CollectionId: inspectCollectionId, MinBalance: "1.5", MaxBalance: "100"},
+			[]masterDbCommon.CollectionResponse{erc20(6)},
+			"(CASE WHEN balance ~ '^[0-9]+$' THEN CAST(balance AS NUMERIC) END >= ? AND " +
+				"CASE WHEN balance ~ '^[0-9]+$' THEN CAST(balance AS NUMERIC) END <= ?)",
+			[]interface{}{"1500000", "100000000"},
+			false,
+		},
+		{
+			"min without decimals",
+			QuerySpec{CollectionId: inspectCollectionId, MinBalance: "7"},
+			[]masterDbCommon.CollectionResponse{erc20(0)},
+			"(CASE WHEN balance ~ '^[0-9]+$' THEN CAST(balance AS NUMERIC) END >= ?)",
+			[]interface{}{"7"},
+			false,
+		},
+		{
+			"without collection",
+			QuerySpec{MinBalance: "1"},
+			nil, "", nil, true,
+		},
+		{
+			"different decimals",
+			QuerySpec{CollectionIds: []string{inspectCollectionId, "1:0x0000000000000000000000000000000000000001"}, MinBalance: "1"},
+			[]masterDbCommon.CollectionResponse{erc20(6), erc20(18)},
+			"", nil, true,
+		},
+		{
+			"too many decimals",
+			QuerySpec{CollectionId: inspectCollectionId, MaxBalance: "0.0000001"},
+			[]masterDbCommon.CollectionResponse{erc20(6)},
+			"", nil, true,
+		},
+		{
+			"negative",
+			QuerySpec{CollectionId: inspectCollectionId, MinBalance: "-1"},
+			[]masterDbCommon.CollectionResponse{erc20(6)},
+			"", nil, true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := resolveBalanceFilters(test.spec, test.collections)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got filter %v, want an error", spec.Filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec.MinBalance != "" || spec.MaxBalance != "" {
+				t.Errorf("got bounds %q and %q left on the spec, want them resolved", spec.MinBalance, spec.MaxBalance)
+			}
+			if test.wantSQL == "" {
+				if spec.Filter != nil {
+					t.Errorf("got filter %v, want none", spec.Filter)
+				}
+				return
+			}
+
+			sqlizer, err := spec.Filter.sqlizer("erc_20_collection_assets", tableColumns["erc_20_collection_assets"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, args, err := sqlizer.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != test.wantSQL {
+				t.Errorf("got SQL %q, want %q", query, test.wantSQL)
+			}
+			if len(args) != len(test.wantArgs) {
+				t.Fatalf("got args %v, want %v", args, test.wantArgs)
+			}
+			for i := range args {
+				if args[i] != test.wantArgs[i] {
+					t.Errorf("got args %v, want %v", args, test.wantArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestToRequestResolvesBalanceBounds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collection := masterDbCommon.CollectionResponse{
+			ChainID:           1,
+			CollectionAddress: path.Base(r.URL.Path),
+			Type:              masterDbCommon.CollectionTypeERC20,
+			DecimalData:       6,
+		}
+		json.NewEncoder(w).Encode(response.HTTPResponse[masterDbCommon.CollectionResponse]{Data: collection})
+	}))
+	defer server.Close()
+
+	config, err := NewMasterDbConfig(nil, server.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := config.CreateQueryBuilder().WithChainId(1).WithCollectionId(inspectCollectionId).
+		WithMinBalance("1.5").WithMaxBalance("100").Build()
+
+	body, err := query.ToRequestContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request struct {
+		Filter json.RawMessage `json:"filter"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"balance"`, `"1500000"`, `"100000000"`} {
+		if !strings.Contains(string(request.Filter), want) {
+			t.Errorf("got filter %s, want it to contain %s", request.Filter, want)
+		}
+	}
+}
+
+// TestBalanceBoundsOnSchema runs balance bounds against the real asset tables,
+// whose balance columns hold decimal integers as text
+func TestBalanceBoundsOnSchema(t *testing.T) {
+	dsn := os.Getenv(schemaDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", schemaDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	collections := []masterDbCommon.CollectionResponse{{DecimalData: 6}}
+	spec, err := resolveBalanceFilters(QuerySpec{
+		ChainId:      1,
+		CollectionId: inspectCollectionId,
+		MinBalance:   "1.5",
+		MaxBalance:   "100",
+		Limit:        10,
+	}, collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tableName := range []string{"erc_1155_collection_assets", "erc_20_collection_assets"} {
+		t.Run(tableName, func(t *testing.T) {
+			var dataType string
+			err := db.QueryRow(
+				"SELECT data_type FROM information_schema.columns WHERE table_name = $1 AND column_name = 'balance'",
+				tableName,
+			).Scan(&dataType)
+			if err != nil {
+				t.Fatalf("reading the type of %s.balance: %v", tableName, err)
+			}
+			if dataType != "text" && dataType != "character varying" {
+				t.Errorf("%s.balance is %s, want text holding decimal integers", tableName, dataType)
+			}
+
+			statements, err := localStatements(spec, tableName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			for name, statement := range map[string]Statement{
+				"data":    statements.Data,
+				"count":   statements.Count,
+				"holders": statements.Holders,
+				"exists":  statements.Exists,
+			} {
+				rows, err := tx.Query(statement.SQL, statement.Args...)
+				if err == nil {
+					for rows.Next() {
+					}
+					err = rows.Err()
+					rows.Close()
+				}
+				if err != nil {
+					t.Fatalf("%s statement %q: %v", name, statement.SQL, err)
+				}
+			}
+		})
+	}
+}
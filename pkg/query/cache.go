@@ -0,0 +1,153 @@
+package query
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache stores asset query results and collection type lookups by key.
+// Values are shared between callers and must be treated as read-only.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value stored under key, if present and not expired
+	Get(key string) (any, bool)
+	// Set stores value under key for ttl and labels it with tags. A ttl of
+	// zero or less keeps the value until it is evicted or invalidated.
+	Set(key string, value any, ttl time.Duration, tags ...string)
+	// Invalidate removes every value labelled with any of the tags
+	Invalidate(tags ...string)
+}
+
+// chainCacheTag labels cached values that belong to a chain
+func chainCacheTag(chainId int32) string {
+	return fmt.Sprintf("chain:%d", chainId)
+}
+
+// collectionCacheTag labels cached values that belong to a collection. Valid
+// collection ids are normalized, so any casing of the address shares a tag.
+func collectionCacheTag(chainId int32, collectionId string) string {
+	if normalized, err := normalizeCollectionId(collectionId); err == nil {
+		collectionId = normalized
+	}
+	return fmt.Sprintf("collection:%d:%s", chainId, collectionId)
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     any
+	expiresAt time.Time
+	tags      []string
+}
+
+// MemoryCache is an in-memory Cache that evicts the least recently used entry
+// once it holds capacity entries, and drops entries past their TTL.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	tagIndex map[string]map[string]struct{}
+}
+
+// NewMemoryCache creates a MemoryCache holding at most capacity entries
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		tagIndex: make(map[string]map[string]struct{}),
+	}
+}
+
+// Get implements Cache.
+func (c *MemoryCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set implements Cache.
+func (c *MemoryCache) Set(key string, value any, ttl time.Duration, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &memoryCacheEntry{key: key, value: value, tags: tags}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for _, tag := range tags {
+		keys, ok := c.tagIndex[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tagIndex[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate implements Cache.
+func (c *MemoryCache) Invalidate(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range c.tagIndex[tag] {
+			if element, ok := c.entries[key]; ok {
+				c.remove(element)
+			}
+		}
+		delete(c.tagIndex, tag)
+	}
+}
+
+// Len returns the number of entries currently stored, including expired
+// entries that have not been evicted yet
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops element from the cache. The caller must hold c.mu.
+func (c *MemoryCache) remove(element *list.Element) {
+	entry := element.Value.(*memoryCacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+
+	for _, tag := range entry.tags {
+		if keys, ok := c.tagIndex[tag]; ok {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(c.tagIndex, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Get("a") // a is now more recent than b
+	cache.Set("c", 3, 0)
+
+	tests := []struct {
+		key  string
+		want any
+		ok   bool
+	}{
+		{"a", 1, true},
+		{"b", nil, false},
+		{"c", 3, true},
+	}
+
+	for _, test := range tests {
+		if value, ok := cache.Get(test.key); ok != test.ok || value != test.want {
+			t.Errorf("Get(%q) = %v, %t, want %v, %t", test.key, value, ok, test.want, test.ok)
+		}
+	}
+	if got := cache.Len(); got != 2 {
+		t.Errorf("got %d entries, want 2", got)
+	}
+}
+
+func TestMemoryCacheCapacity(t *testing.T) {
+	tests := []struct {
+		capacity int
+		want     int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, test := range tests {
+		cache := NewMemoryCache(test.capacity)
+		for _, key := range []string{"a", "b", "c", "d"} {
+			cache.Set(key, key, 0)
+		}
+		if got := cache.Len(); got != test.want {
+			t.Errorf("capacity %d holds %d entries, want %d", test.capacity, got, test.want)
+		}
+		if _, ok := cache.Get("d"); !ok {
+			t.Errorf("capacity %d evicted the newest entry", test.capacity)
+		}
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	cache := NewMemoryCache(10)
+
+	tests := []struct {
+		key  string
+		ttl  time.Duration
+		want bool
+	}{
+		{"short", 10 * time.Millisecond, false},
+		{"long", time.Hour, true},
+		{"zero", 0, true},
+		{"negative", -time.Second, true},
+	}
+
+	for _, test := range tests {
+		cache.Set(test.key, test.key, test.ttl)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	for _, test := range tests {
+		if _, ok := cache.Get(test.key); ok != test.want {
+			t.Errorf("Get(%q) with ttl %s found %t, want %t", test.key, test.ttl, ok, test.want)
+		}
+	}
+	// Expired entries are dropped when read
+	if got := cache.Len(); got != 3 {
+		t.Errorf("got %d entries, want 3", got)
+	}
+}
+
+func TestMemoryCacheInvalidate(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{"chain", []string{"chain:1"}, []string{"c"}},
+		{"collection", []string{"collection:1:0xa"}, []string{"b", "c"}},
+		{"several tags", []string{"collection:1:0xa", "chain:2"}, []string{"b"}},
+		{"unknown tag", []string{"chain:3"}, []string{"a", "b", "c"}},
+		{"no tags", nil, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewMemoryCache(10)
+			cache.Set("a", "a", 0, "chain:1", "collection:1:0xa")
+			cache.Set("b", "b", 0, "chain:1", "collection:1:0xb")
+			cache.Set("c", "c", 0, "chain:2")
+
+			cache.Invalidate(test.tags...)
+
+			var got []string
+			for _, key := range []string{"a", "b", "c"} {
+				if _, ok := cache.Get(key); ok {
+					got = append(got, key)
+				}
+			}
+			if len(got) != len(test.want) || cache.Len() != len(test.want) {
+				t.Fatalf("got entries %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got entries %q, want %q", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCacheOverwriteReplacesTags(t *testing.T) {
+	cache := NewMemoryCache(10)
+	cache.Set("key", 1, 0, "old")
+	cache.Set("key", 2, 0, "new")
+
+	cache.Invalidate("old")
+	if value, ok := cache.Get("key"); !ok || value != 2 {
+		t.Errorf("got %v, %t after invalidating the replaced tag, want 2", value, ok)
+	}
+
+	cache.Invalidate("new")
+	if _, ok := cache.Get("key"); ok {
+		t.Error("entry kept after invalidating its tag")
+	}
+	if len(cache.tagIndex) != 0 {
+		t.Errorf("got tag index %v, want it empty", cache.tagIndex)
+	}
+}
+
+// racingCache invalidates the config while a value is being stored
+type racingCache struct {
+	*MemoryCache
+	config      *masterDbConfig
+	invalidated chan struct{}
+}
+
+func (c *racingCache) Set(key string, value any, ttl time.Duration, tags ...string) {
+	go func() {
+		c.config.InvalidateChain(1)
+		close(c.invalidated)
+	}()
+	// Give the invalidation every chance to run before the value lands
+	time.Sleep(20 * time.Millisecond)
+	c.MemoryCache.Set(key, value, ttl, tags...)
+}
+
+func TestCachedStoreRacingInvalidation(t *testing.T) {
+	cache := &racingCache{MemoryCache: NewMemoryCache(10), invalidated: make(chan struct{})}
+	config, err := NewMasterDbConfig(nil, "http://master", true, WithCache(cache, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.config = config
+	b := &assetQueryBuilderParam{config: config}
+
+	_, err = b.cached(context.Background(), "key", time.Hour, []string{chainCacheTag(1)}, func(ctx context.Context) (any, error) {
+		return "stale", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cache.invalidated
+
+	if value, ok := cache.Get("key"); ok {
+		t.Errorf("got %v cached after the invalidation, want nothing", value)
+	}
+}
+
+func TestCachedSkipsFetchesRacedByInvalidation(t *testing.T) {
+	cache := NewMemoryCache(10)
+	config, err := NewMasterDbConfig(nil, "http://master", true, WithCache(cache, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &assetQueryBuilderParam{config: config}
+
+	_, err = b.cached(context.Background(), "key", time.Hour, []string{chainCacheTag(1)}, func(ctx context.Context) (any, error) {
+		config.InvalidateCollection(1, "1:0x0091bd12166d29539db6bb37fb79670779abf266")
+		return "stale", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := cache.Get("key"); ok {
+		t.Errorf("got %v cached from a fetch started before the invalidation, want nothing", value)
+	}
+}
+
+func TestCachedSkipsErrors(t *testing.T) {
+	cache := NewMemoryCache(10)
+	config, err := NewMasterDbConfig(nil, "http://master", true, WithCache(cache, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &assetQueryBuilderParam{config: config}
+
+	failure := errors.New("master unavailable")
+	if _, err := b.cached(context.Background(), "key", time.Hour, nil, func(ctx context.Context) (any, error) {
+		return "partial", failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("got %d entries after a failed fetch, want none", cache.Len())
+	}
+}
+
+func TestQueryCacheOverrides(t *testing.T) {
+	const collectionId = "1:0x0091bd12166d29539db6bb37fb79670779abf266"
+
+	same := func(b AssetQueryBuilder) AssetQueryBuilder { return b }
+	tests := []struct {
+		name          string
+		first, second func(b AssetQueryBuilder) AssetQueryBuilder
+		pause         time.Duration // Between the two queries
+		wantRequests  int
+	}{
+		{"config ttl", same, same, 0, 1},
+		{"no cache", same, func(b AssetQueryBuilder) AssetQueryBuilder { return b.WithNoCache() }, 0, 2},
+		{"no cache does not store", func(b AssetQueryBuilder) AssetQueryBuilder { return b.WithNoCache() }, same, 0, 2},
+		{"query ttl reads the cache", same, func(b AssetQueryBuilder) AssetQueryBuilder { return b.WithCacheTTL(time.Minute) }, 0, 1},
+		{
+			"query ttl expires",
+			func(b AssetQueryBuilder) AssetQueryBuilder { return b.WithCacheTTL(10 * time.Millisecond) },
+			same, 50 * time.Millisecond, 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			master := &fakeMaster{assets: chunkAssets(3)}
+			server := httptest.NewServer(master)
+			defer server.Close()
+
+			config, err := NewMasterDbConfig(nil, server.URL, true, WithCache(NewMemoryCache(10), time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := func(configure func(b AssetQueryBuilder) AssetQueryBuilder) {
+				builder := configure(config.CreateQueryBuilder().WithChainId(1).WithCollectionId(collectionId))
+				if _, err := builder.Build().GetPaginatedAsset(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			query(test.first)
+			time.Sleep(test.pause)
+			query(test.second)
+
+			if got := master.requestCount(); got != test.wantRequests {
+				t.Errorf("got %d master queries, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWithCacheTTLErrors(t *testing.T) {
+	withCache, err := NewMasterDbConfig(nil, "http://master", true, WithCache(NewMemoryCache(10), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutCache, err := NewMasterDbConfig(nil, "http://master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config *masterDbConfig
+		ttl    time.Duration
+	}{
+		{"zero", withCache, 0},
+		{"negative", withCache, -time.Second},
+		{"without cache", withoutCache, time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := test.config.CreateQueryBuilder().WithChainId(1).WithCacheTTL(test.ttl)
+			if _, err := builder.Build().GetPaginatedAsset(); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
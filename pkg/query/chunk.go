@@ -0,0 +1,200 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"asset-query/pkg/models"
+)
+
+// tokenIdChunks splits the distinct tokenIds into chunks of at most size ids.
+// It returns nil when they fit in one chunk.
+func tokenIdChunks(tokenIds []string, size int) [][]string {
+	if size < 1 || len(tokenIds) <= size {
+		return nil
+	}
+
+	distinct := slices.Clone(tokenIds)
+	slices.Sort(distinct)
+	distinct = slices.Compact(distinct)
+	if len(distinct) <= size {
+		return nil
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(distinct); start += size {
+		chunks = append(chunks, distinct[start:min(start+size, len(distinct))])
+	}
+	return chunks
+}
+
+// withTokenIds returns a copy of spec restricted to tokenIds
+func (s QuerySpec) withTokenIds(tokenIds []string) QuerySpec {
+	chunk := s.Clone()
+	chunk.TokenIds = tokenIds
+	return chunk
+}
+
+// queryMasterChunkedPage fetches the page of spec from the master one chunk
+// of token ids at a time. Every chunk returns its first Offset+Limit assets,
+// which are merged in the order of spec before the page is cut, so the window
+// must fit in one page of the master: deeper pages are rejected. The merge
+// orders assets by their order columns, which must be selected. The chunks
+// hold distinct token ids, so their totals add up, but their holders do not
+// and are reported as unknown.
+func queryMasterChunkedPage[T any](ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec, chunks [][]string) (Pagination[T], error) {
+	window := spec.Offset + spec.Limit
+	if window > b.config.maxLimit {
+		return Pagination[T]{}, fmt.Errorf("queries with more than %d token ids cannot page past the first %d assets",
+			b.config.tokenIdChunkSize, b.config.maxLimit)
+	}
+	if len(spec.Fields) > 0 {
+		for _, column := range orderColumns(spec.Order) {
+			if !slices.Contains(spec.Fields, column) {
+				return Pagination[T]{}, fmt.Errorf("queries with more than %d token ids must select the order column %q",
+					b.config.tokenIdChunkSize, column)
+			}
+		}
+	}
+
+	// Holders stays nil: distinct owners of different chunks do not add up
+	merged := Pagination[T]{Page: spec.Page, Limit: spec.Limit}
+
+	var assets []T
+	hasNext := false
+	for _, chunk := range chunks {
+		chunkSpec := spec.withTokenIds(chunk)
+		chunkSpec.Page, chunkSpec.Limit, chunkSpec.Offset = 1, window, 0
+
+		page, err := queryMasterPage[T](ctx, b, chunkSpec)
+		if err != nil {
+			return Pagination[T]{}, err
+		}
+
+		assets = append(assets, page.Data...)
+		merged.TotalItems += page.TotalItems
+		merged.ApproximateTotal = merged.ApproximateTotal || page.ApproximateTotal
+		hasNext = hasNext || page.HasNext
+	}
+
+	sortAssets(assets, spec.Order)
+	if len(assets) > window {
+		hasNext = true
+	}
+	merged.Data = assets[min(spec.Offset, len(assets)):min(window, len(assets))]
+
+	if spec.WithoutTotal {
+		merged.TotalItems = 0
+		merged.HasNext = hasNext
+		return merged, nil
+	}
+
+	merged.TotalPages = (merged.TotalItems + int64(spec.Limit) - 1) / int64(spec.Limit)
+	merged.HasNext = int64(merged.Page) < merged.TotalPages
+	return merged, nil
+}
+
+// queryMasterChunkedCount adds up the counts of every chunk of token ids
+func queryMasterChunkedCount(ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec, chunks [][]string) (int64, error) {
+	var total int64
+	for _, chunk := range chunks {
+		count, err := queryMasterCount(ctx, b, spec.withTokenIds(chunk))
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// queryMasterChunkedExists checks the chunks of token ids until one matches
+func queryMasterChunkedExists(ctx context.Context, b *assetQueryBuilderParam, spec QuerySpec, chunks [][]string) (bool, error) {
+	for _, chunk := range chunks {
+		found, err := queryMasterExists(ctx, b, spec.withTokenIds(chunk))
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// orderColumns returns the columns assets are ordered by for order, matching
+// the ORDER BY clauses of local queries
+func orderColumns(order AssetOrder) []string {
+	switch order {
+	case OrderByTokenId:
+		return []string{"token_id", "id"}
+	case OrderByUpdatedAt:
+		return []string{"updated_at", "id"}
+	}
+	return []string{"created_at", "id"}
+}
+
+// sortAssets sorts assets in order. Columns missing from T do not take part in
+// the comparison, so callers must make sure the order columns were selected.
+func sortAssets[T any](assets []T, order AssetOrder) {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	if itemType.Kind() != reflect.Struct {
+		return
+	}
+
+	scanner := scannerFor(itemType)
+	var indexes [][]int
+	var columns []string
+	for _, column := range orderColumns(order) {
+		if index := scanner.fields[column]; index != nil {
+			indexes = append(indexes, index)
+			columns = append(columns, column)
+		}
+	}
+
+	slices.SortStableFunc(assets, func(a, b T) int {
+		aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+		for i, index := range indexes {
+			aField, aErr := aValue.FieldByIndexErr(index)
+			bField, bErr := bValue.FieldByIndexErr(index)
+			if aErr != nil || bErr != nil {
+				continue
+			}
+			if c := compareField(columns[i], aField, bField); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+// compareField compares two values of column the way Postgres orders them
+func compareField(column string, a reflect.Value, b reflect.Value) int {
+	if column == "token_id" && a.Kind() == reflect.String {
+		return compareTokenIds(a.String(), b.String())
+	}
+
+	switch a := a.Interface().(type) {
+	case time.Time:
+		return a.Compare(b.Interface().(time.Time))
+	case interface{ String() string }:
+		return strings.Compare(a.String(), b.Interface().(interface{ String() string }).String())
+	}
+	return 0
+}
+
+// compareTokenIds orders numeric token ids numerically before any other
+// token id, which are ordered by their text
+func compareTokenIds(a string, b string) int {
+	aNumber, aErr := models.ParseUint256(a)
+	bNumber, bErr := models.ParseUint256(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return aNumber.Big().Cmp(bNumber.Big())
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,280 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"asset-query/internal/response"
+	"asset-query/pkg/models"
+
+	"github.com/google/uuid"
+	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
+)
+
+func TestTokenIdChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokenIds []string
+		size     int
+		want     [][]string
+	}{
+		{"empty", nil, 2, nil},
+		{"fits one chunk", []string{"1", "2"}, 2, nil},
+		{"duplicates fit one chunk", []string{"1", "2", "1", "2"}, 2, nil},
+		{"invalid size", []string{"1", "2", "3"}, 0, nil},
+		{"exact chunks", []string{"4", "3", "2", "1"}, 2, [][]string{{"1", "2"}, {"3", "4"}}},
+		{"last chunk shorter", []string{"1", "2", "3"}, 2, [][]string{{"1", "2"}, {"3"}}},
+		{"duplicates removed", []string{"3", "1", "3", "2", "1"}, 2, [][]string{{"1", "2"}, {"3"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := tokenIdChunks(test.tokenIds, test.size); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("tokenIdChunks(%q, %d) = %q, want %q", test.tokenIds, test.size, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenIdChunksKeepsInput(t *testing.T) {
+	tokenIds := []string{"3", "1", "2"}
+	tokenIdChunks(tokenIds, 1)
+	if !slices.Equal(tokenIds, []string{"3", "1", "2"}) {
+		t.Errorf("token ids modified to %q", tokenIds)
+	}
+}
+
+func TestCompareTokenIds(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "1", 0},
+		{"2", "10", -1},
+		{"10", "2", 1},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", "1", 1},
+		{"9", "a", -1},
+		{"a", "9", 1},
+		{"a", "b", -1},
+		{"-1", "1", 1},
+		{"0x10", "0x9", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			if got := compareTokenIds(test.a, test.b); got != test.want {
+				t.Errorf("compareTokenIds(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+// fakeMaster serves /query-builder pages of assets, which must already be in
+// the order of every query it receives, and records the request bodies.
+// Every collection it is asked about is an ERC721 collection.
+type fakeMaster struct {
+	assets []models.Erc721CollectionAsset
+
+	mu       sync.Mutex
+	requests []QuerySpec
+}
+
+func (m *fakeMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/chain/") {
+		collection := masterDbCommon.CollectionResponse{
+			ChainID:           1,
+			CollectionAddress: path.Base(r.URL.Path),
+			Type:              masterDbCommon.CollectionTypeERC721,
+		}
+		json.NewEncoder(w).Encode(response.HTTPResponse[masterDbCommon.CollectionResponse]{Data: collection})
+		return
+	}
+
+	var spec QuerySpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	m.requests = append(m.requests, spec)
+	m.mu.Unlock()
+
+	var matching []models.Erc721CollectionAsset
+	for _, asset := range m.assets {
+		if spec.TokenIds == nil || slices.Contains(spec.TokenIds, asset.TokenID) {
+			matching = append(matching, asset)
+		}
+	}
+
+	offset := (spec.Page - 1) * spec.Limit
+	page := Pagination[models.Erc721CollectionAsset]{
+		Page:       spec.Page,
+		Limit:      spec.Limit,
+		TotalItems: int64(len(matching)),
+		TotalPages: (int64(len(matching)) + int64(spec.Limit) - 1) / int64(spec.Limit),
+		Data:       matching[min(offset, len(matching)):min(offset+spec.Limit, len(matching))],
+		HasNext:    offset+spec.Limit < len(matching),
+	}
+	holders := int64(1)
+	page.Holders = &holders
+
+	json.NewEncoder(w).Encode(response.HTTPResponse[Pagination[models.Erc721CollectionAsset]]{Data: page})
+}
+
+// requestCount returns the number of /query-builder requests served
+func (m *fakeMaster) requestCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.requests)
+}
+
+// chunkAssets returns assets with token ids 1 to n, created in the reverse
+// order of their token ids and updated in the order of their token ids
+func chunkAssets(n int) []models.Erc721CollectionAsset {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	assets := make([]models.Erc721CollectionAsset, n)
+	for i := range assets {
+		assets[i] = models.Erc721CollectionAsset{
+			ID:        uuid.New(),
+			ChainID:   1,
+			TokenID:   fmt.Sprint(i + 1),
+			CreatedAt: start.Add(time.Duration(n-i) * time.Hour),
+			UpdatedAt: start.Add(time.Duration(i) * time.Hour),
+		}
+	}
+	return assets
+}
+
+// chunkTokenIds returns the token ids of assets
+func chunkTokenIds(assets []models.Erc721CollectionAsset) []string {
+	tokenIds := make([]string, len(assets))
+	for i, asset := range assets {
+		tokenIds[i] = asset.TokenID
+	}
+	return tokenIds
+}
+
+func TestQueryMasterChunkedPage(t *testing.T) {
+	assets := chunkAssets(10)
+	byTokenId := slices.Clone(assets)
+	byCreatedAt := slices.Clone(assets)
+	slices.Reverse(byCreatedAt)
+
+	tests := []struct {
+		name         string
+		assets       []models.Erc721CollectionAsset // In the order of the query
+		order        AssetOrder
+		page         int
+		limit        int
+		withoutTotal bool
+		want         []string
+		wantTotal    int64
+		wantHasNext  bool
+	}{
+		{"first page by token id", byTokenId, OrderByTokenId, 1, 3, false, []string{"1", "2", "3"}, 10, true},
+		{"page across chunks by token id", byTokenId, OrderByTokenId, 2, 3, false, []string{"4", "5", "6"}, 10, true},
+		{"numeric token ids", byTokenId, OrderByTokenId, 3, 3, false, []string{"7", "8", "9"}, 10, true},
+		{"last page", byTokenId, OrderByTokenId, 4, 3, false, []string{"10"}, 10, false},
+		{"past the last page", byTokenId, OrderByTokenId, 5, 3, false, []string{}, 10, false},
+		{"by created at", byCreatedAt, OrderByCreatedAt, 1, 4, false, []string{"10", "9", "8", "7"}, 10, true},
+		{"by updated at", byTokenId, OrderByUpdatedAt, 2, 4, false, []string{"5", "6", "7", "8"}, 10, true},
+		{"without total", byTokenId, OrderByTokenId, 3, 3, true, []string{"7", "8", "9"}, 0, true},
+		{"last page without total", byTokenId, OrderByTokenId, 4, 3, true, []string{"10"}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			master := &fakeMaster{assets: test.assets}
+			server := httptest.NewServer(master)
+			defer server.Close()
+
+			config, err := NewMasterDbConfig(nil, server.URL, true, WithTokenIdChunkSize(4), WithMaxLimit(20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := QuerySpec{
+				ChainId:      1,
+				TokenIds:     chunkTokenIds(assets),
+				Order:        test.order,
+				WithoutTotal: test.withoutTotal,
+				Page:         test.page,
+				Limit:        test.limit,
+				Offset:       (test.page - 1) * test.limit,
+			}
+
+			page, err := queryMasterPage[models.Erc721CollectionAsset](context.Background(), &assetQueryBuilderParam{config: config}, spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := chunkTokenIds(page.Data); !slices.Equal(got, test.want) {
+				t.Errorf("got token ids %q, want %q", got, test.want)
+			}
+			if page.TotalItems != test.wantTotal || page.HasNext != test.wantHasNext {
+				t.Errorf("got total %d and has next %t, want %d and %t", page.TotalItems, page.HasNext, test.wantTotal, test.wantHasNext)
+			}
+			if page.Holders != nil {
+				t.Errorf("got %d holders, want unknown", *page.Holders)
+			}
+			if page.Page != test.page || page.Limit != test.limit {
+				t.Errorf("got page %d of %d, want page %d of %d", page.Page, page.Limit, test.page, test.limit)
+			}
+
+			if len(master.requests) != 3 {
+				t.Fatalf("got %d master requests, want 3", len(master.requests))
+			}
+			for _, request := range master.requests {
+				if len(request.TokenIds) > 4 || request.Page != 1 || request.Offset != 0 || request.Limit != test.page*test.limit {
+					t.Errorf("chunk requested %d token ids, page %d, offset %d and limit %d",
+						len(request.TokenIds), request.Page, request.Offset, request.Limit)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMasterChunkedPageRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   QuerySpec
+		reason string
+	}{
+		{"past the max limit", QuerySpec{Page: 2, Limit: 20, Offset: 20}, "cannot page past"},
+		{"order column not selected", QuerySpec{Page: 1, Limit: 5, Fields: []string{"token_id", "owner"}}, `"created_at"`},
+		{"tie breaker not selected", QuerySpec{Order: OrderByTokenId, Page: 1, Limit: 5, Fields: []string{"token_id"}}, `"id"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			master := &fakeMaster{}
+			server := httptest.NewServer(master)
+			defer server.Close()
+
+			config, err := NewMasterDbConfig(nil, server.URL, true, WithTokenIdChunkSize(4), WithMaxLimit(20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := test.spec
+			spec.ChainId = 1
+			spec.TokenIds = chunkTokenIds(chunkAssets(10))
+
+			_, err = queryMasterPage[models.Erc721CollectionAsset](context.Background(), &assetQueryBuilderParam{config: config}, spec)
+			if err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Errorf("got error %v, want one containing %s", err, test.reason)
+			}
+			if len(master.requests) != 0 {
+				t.Errorf("got %d master requests, want none", len(master.requests))
+			}
+		})
+	}
+}
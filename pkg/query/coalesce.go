@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// flightCall is an execution shared by every caller of the same key
+type flightCall struct {
+	done  chan struct{}
+	val   any
+	err   error
+	panic any
+}
+
+// flightPanic is the value re-panicked by callers that joined an execution
+// whose fn panicked
+type flightPanic struct {
+	value any
+}
+
+func (p flightPanic) Error() string {
+	return fmt.Sprintf("coalesced query panicked: %v", p.value)
+}
+
+// flightGroup coalesces concurrent executions with the same key into one.
+// Results are shared between callers and must be treated as read-only.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn once for all concurrent callers of key. A caller that joins a
+// running execution stops waiting when its own ctx is done, and runs fn itself
+// if the shared execution failed only because the first caller's ctx ended.
+// A panic in fn is re-panicked in the caller that ran it and in every caller
+// waiting on it.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	for {
+		g.mu.Lock()
+		if call, ok := g.calls[key]; ok {
+			g.mu.Unlock()
+
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			if call.panic != nil {
+				panic(flightPanic{call.panic})
+			}
+			if isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			return call.val, call.err
+		}
+
+		call := &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		g.mu.Unlock()
+
+		g.run(ctx, key, call, fn)
+		return call.val, call.err
+	}
+}
+
+// run executes fn for call, always releasing key and its waiters
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fn func(ctx context.Context) (any, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.panic = r
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+
+		if call.panic != nil {
+			panic(call.panic)
+		}
+	}()
+
+	call.val, call.err = fn(ctx)
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,250 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// joinDelay is how long tests give callers to join a running execution
+const joinDelay = 50 * time.Millisecond
+
+func TestFlightGroupSharesOneCall(t *testing.T) {
+	group := newFlightGroup()
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (any, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return "page", nil
+	}
+
+	const callers = 10
+	results := make(chan any, callers)
+	var wg sync.WaitGroup
+	call := func() {
+		defer wg.Done()
+		value, err := group.do(context.Background(), "key", fn)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- value
+	}
+
+	wg.Add(callers)
+	go call()
+	<-started
+	for i := 1; i < callers; i++ {
+		go call()
+	}
+	time.Sleep(joinDelay)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fn ran %d times, want once", got)
+	}
+	for value := range results {
+		if value != "page" {
+			t.Errorf("got %v, want the shared page", value)
+		}
+	}
+}
+
+func TestFlightGroupSharesErrors(t *testing.T) {
+	group := newFlightGroup()
+	failure := errors.New("master unavailable")
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	leader := make(chan error, 1)
+	go func() {
+		_, err := group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+			close(started)
+			<-release
+			return nil, failure
+		})
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+			return "not shared", nil
+		})
+		waiter <- err
+	}()
+	time.Sleep(joinDelay)
+	close(release)
+
+	if err := <-leader; !errors.Is(err, failure) {
+		t.Errorf("leader got %v, want %v", err, failure)
+	}
+	if err := <-waiter; !errors.Is(err, failure) {
+		t.Errorf("waiter got %v, want the shared %v", err, failure)
+	}
+}
+
+func TestFlightGroupReleasesKeys(t *testing.T) {
+	group := newFlightGroup()
+	var calls atomic.Int32
+	fn := func(ctx context.Context) (any, error) {
+		return calls.Add(1), nil
+	}
+
+	tests := []struct {
+		key  string
+		want int32
+	}{
+		{"a", 1},
+		{"a", 2}, // A finished call is not reused
+		{"b", 3},
+	}
+
+	for _, test := range tests {
+		value, err := group.do(context.Background(), test.key, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != test.want {
+			t.Errorf("call of %q got %v, want %d", test.key, value, test.want)
+		}
+	}
+	if len(group.calls) != 0 {
+		t.Errorf("got %d calls left in flight, want none", len(group.calls))
+	}
+}
+
+func TestFlightGroupRetriesCancelledLeader(t *testing.T) {
+	group := newFlightGroup()
+	var calls atomic.Int32
+	started := make(chan struct{})
+
+	fn := func(ctx context.Context) (any, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return "page", nil
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := group.do(leaderCtx, "key", fn)
+		leader <- err
+	}()
+	<-started
+
+	type result struct {
+		value any
+		err   error
+	}
+	waiter := make(chan result, 1)
+	go func() {
+		value, err := group.do(context.Background(), "key", fn)
+		waiter <- result{value, err}
+	}()
+	time.Sleep(joinDelay)
+	cancelLeader()
+
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader got %v, want %v", err, context.Canceled)
+	}
+	// The waiter's ctx is still live, so it runs fn itself
+	if got := <-waiter; got.err != nil || got.value != "page" {
+		t.Errorf("waiter got %v and %v, want the page from its own call", got.value, got.err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("fn ran %d times, want twice", got)
+	}
+}
+
+func TestFlightGroupWaiterCancelled(t *testing.T) {
+	group := newFlightGroup()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	leader := make(chan any, 1)
+	go func() {
+		value, _ := group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+			close(started)
+			<-release
+			return "page", nil
+		})
+		leader <- value
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := group.do(ctx, "key", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiter got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The leader is not affected by the waiter giving up
+	close(release)
+	if value := <-leader; value != "page" {
+		t.Errorf("leader got %v, want the page", value)
+	}
+}
+
+func TestFlightGroupReleasesPanics(t *testing.T) {
+	group := newFlightGroup()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	recovered := func(fn func()) (value any) {
+		defer func() { value = recover() }()
+		fn()
+		return nil
+	}
+
+	leader := make(chan any, 1)
+	go func() {
+		leader <- recovered(func() {
+			group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+				close(started)
+				<-release
+				panic("scan failed")
+			})
+		})
+	}()
+	<-started
+
+	waiter := make(chan any, 1)
+	go func() {
+		waiter <- recovered(func() {
+			group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+				return "not shared", nil
+			})
+		})
+	}()
+	time.Sleep(joinDelay)
+	close(release)
+
+	if got := <-leader; got != "scan failed" {
+		t.Errorf("leader panicked with %v, want the original value", got)
+	}
+	if got, ok := (<-waiter).(flightPanic); !ok || got.value != "scan failed" {
+		t.Errorf("waiter panicked with %#v, want a flightPanic of the original value", got)
+	}
+
+	// The key was released, so the next call runs
+	value, err := group.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+		return "page", nil
+	})
+	if err != nil || value != "page" {
+		t.Errorf("call after the panic got %v and %v, want the page", value, err)
+	}
+}
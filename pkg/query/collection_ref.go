@@ -0,0 +1,126 @@
+package query
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidCollectionRef is returned when a collection id is not of the form "chainId:address"
+	ErrInvalidCollectionRef = errors.New("invalid collection ref")
+	// ErrChainMismatch is returned when the chain of a collection id differs from the chain of the query
+	ErrChainMismatch = errors.New("collection chain does not match query chain")
+)
+
+// CollectionRef identifies a collection by its chain and contract address,
+// written "chainId:address" as in "1:0x0091BD12166d29539Db6bb37FB79670779aBf266".
+// It is encoded as that string in JSON and SQL. The zero value is no collection.
+type CollectionRef struct {
+	ChainId int32
+	Address string // EIP-55 0x address
+}
+
+// NewCollectionRef returns the validated reference of address on chainId
+func NewCollectionRef(chainId int32, address string) (CollectionRef, error) {
+	ref := CollectionRef{ChainId: chainId, Address: address}
+	if err := ref.Validate(); err != nil {
+		return CollectionRef{}, err
+	}
+
+	ref.Address, _ = normalizeAddress(address)
+	return ref, nil
+}
+
+// ParseCollectionRef parses a "chainId:address" collection id
+func ParseCollectionRef(s string) (CollectionRef, error) {
+	chain, address, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		return CollectionRef{}, fmt.Errorf("%w: %q has no chain prefix", ErrInvalidCollectionRef, s)
+	}
+
+	chainId, err := strconv.ParseInt(chain, 10, 32)
+	if err != nil {
+		return CollectionRef{}, fmt.Errorf("%w: %q has an invalid chain id", ErrInvalidCollectionRef, s)
+	}
+	return NewCollectionRef(int32(chainId), address)
+}
+
+// MustParseCollectionRef is like ParseCollectionRef but panics on error
+func MustParseCollectionRef(s string) CollectionRef {
+	ref, err := ParseCollectionRef(s)
+	if err != nil {
+		panic(err)
+	}
+	return ref
+}
+
+// Validate checks that the chain id is positive and the address is valid
+func (r CollectionRef) Validate() error {
+	if r.ChainId <= 0 {
+		return fmt.Errorf("%w: chain id must be positive", ErrInvalidCollectionRef)
+	}
+	if _, err := normalizeAddress(r.Address); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCollectionRef, err)
+	}
+	return nil
+}
+
+// IsZero reports whether r is the zero value
+func (r CollectionRef) IsZero() bool {
+	return r == CollectionRef{}
+}
+
+// String returns "chainId:address" with the address in EIP-55 form, or ""
+// for the zero value
+func (r CollectionRef) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", r.ChainId, checksumAddress(r.Address))
+}
+
+// MarshalText implements encoding.TextMarshaler, and so JSON encoding
+func (r CollectionRef) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, and so JSON decoding.
+// An empty string decodes to the zero value.
+func (r *CollectionRef) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = CollectionRef{}
+		return nil
+	}
+
+	ref, err := ParseCollectionRef(string(text))
+	if err != nil {
+		return err
+	}
+	*r = ref
+	return nil
+}
+
+// Scan implements sql.Scanner. NULL scans as the zero value.
+func (r *CollectionRef) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*r = CollectionRef{}
+		return nil
+	case []byte:
+		return r.UnmarshalText(src)
+	case string:
+		return r.UnmarshalText([]byte(src))
+	}
+	return fmt.Errorf("cannot scan %T into CollectionRef", src)
+}
+
+// Value implements driver.Valuer. The zero value is stored as NULL.
+func (r CollectionRef) Value() (driver.Value, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+	return r.String(), nil
+}
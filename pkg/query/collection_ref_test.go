@@ -0,0 +1,142 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseCollectionRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    CollectionRef
+		wantErr bool
+	}{
+		{"lower case", "1:0x0091bd12166d29539db6bb37fb79670779abf266", CollectionRef{1, collectionAddress}, false},
+		{"whitespace", " 2:0x0091bd12166d29539db6bb37fb79670779abf266 ", CollectionRef{2, collectionAddress}, false},
+		{"largest chain id", "2147483647:" + collectionAddress, CollectionRef{2147483647, collectionAddress}, false},
+		{"no prefix", collectionAddress, CollectionRef{}, true},
+		{"bad prefix", "eth:" + collectionAddress, CollectionRef{}, true},
+		{"empty prefix", ":" + collectionAddress, CollectionRef{}, true},
+		{"zero chain id", "0:" + collectionAddress, CollectionRef{}, true},
+		{"negative chain id", "-1:" + collectionAddress, CollectionRef{}, true},
+		{"overflowing chain id", "2147483648:" + collectionAddress, CollectionRef{}, true},
+		{"invalid address", "1:0x0091", CollectionRef{}, true},
+		{"empty", "", CollectionRef{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseCollectionRef(test.s)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidCollectionRef) {
+					t.Errorf("got %v and error %v, want %v", got, err, ErrInvalidCollectionRef)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCollectionRefText(t *testing.T) {
+	ref := CollectionRef{ChainId: 1, Address: collectionAddress}
+
+	encoded, err := json.Marshal(struct {
+		Collection CollectionRef `json:"collection"`
+	}{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"collection":"1:` + collectionAddress + `"}`; string(encoded) != want {
+		t.Errorf("got %s, want %s", encoded, want)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    CollectionRef
+		wantErr bool
+	}{
+		{"checksummed", "1:" + collectionAddress, ref, false},
+		{"lower case", "1:0x0091bd12166d29539db6bb37fb79670779abf266", ref, false},
+		{"empty", "", CollectionRef{}, false},
+		{"invalid", "1:0x0091", CollectionRef{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CollectionRef{ChainId: 9, Address: ownerAddress} // Decoding replaces any previous value
+			err := got.UnmarshalText([]byte(test.text))
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("decoded %q as %v, want an error", test.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("decoded %q as %v, want %v", test.text, got, test.want)
+			}
+
+			text, err := got.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.want.String(); string(text) != want {
+				t.Errorf("encoded %v as %q, want %q", got, text, want)
+			}
+		})
+	}
+}
+
+func TestCollectionRefSQL(t *testing.T) {
+	ref := CollectionRef{ChainId: 1, Address: collectionAddress}
+
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    CollectionRef
+		wantErr bool
+	}{
+		{"null", nil, CollectionRef{}, false},
+		{"string", "1:0x0091bd12166d29539db6bb37fb79670779abf266", ref, false},
+		{"bytes", []byte("1:" + collectionAddress), ref, false},
+		{"invalid", "1:0x0091", CollectionRef{}, true},
+		{"int64", int64(1), CollectionRef{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CollectionRef{ChainId: 9, Address: ownerAddress}
+			err := got.Scan(test.src)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("scanned %v as %v, want an error", test.src, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("scanned %v as %v, want %v", test.src, got, test.want)
+			}
+		})
+	}
+
+	if value, err := (CollectionRef{}).Value(); err != nil || value != nil {
+		t.Errorf("zero value stored as %v and %v, want NULL", value, err)
+	}
+	if value, err := ref.Value(); err != nil || value != "1:"+collectionAddress {
+		t.Errorf("stored as %v and %v, want %q", value, err, "1:"+collectionAddress)
+	}
+}
@@ -1,7 +1,9 @@
 package query
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
@@ -22,26 +24,66 @@ func toSnakeCase(s string) string {
 	return result.String()
 }
 
-// QueryWithDynamicFilter retrieves a slice of items from the database
-func QueryWithDynamicFilter[T any](db *sql.DB, tableName string, limit int, offset int, filterConditions map[string][]string) ([]T, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-	queryBuilder := psql.Select("*").From(tableName)
-
-	// Apply dynamic filters
-	for column, values := range filterConditions {
-		if column == "created_at_from" {
-			fmt.Println("created_at_from", values[0])
-			queryBuilder = queryBuilder.Where(squirrel.GtOrEq{"created_at": values[0]})
-		} else if column == "created_at_to" {
-			fmt.Println("created_at_to", values[0])
-			queryBuilder = queryBuilder.Where(squirrel.LtOrEq{"created_at": values[0]})
-		} else if len(values) == 1 {
-			queryBuilder = queryBuilder.Where(squirrel.Eq{column: values[0]})
-		} else if len(values) > 1 {
-			queryBuilder = queryBuilder.Where(squirrel.Eq{column: values})
+// orderClauses returns the ORDER BY clauses of order. Every order ends with
+// the id, so pages never overlap or skip items however many owners or
+// collections a query spans.
+//
+// Local queries used to have no ORDER BY, so pages came back in whatever order
+// Postgres chose. They are now ordered by created_at and id by default, and
+// need an index on (created_at, id) to stay cheap on deep pages, see
+// migrations/0002_order_indexes.sql.
+func orderClauses(tableName string, order AssetOrder) ([]string, error) {
+	switch order {
+	case OrderByCreatedAt:
+		return []string{"created_at", "id"}, nil
+	case OrderByUpdatedAt:
+		return []string{"updated_at", "id"}, nil
+	case OrderByTokenId:
+		columns, err := validateTable(tableName)
+		if err != nil {
+			return nil, err
 		}
+		if _, ok := columns["token_id"]; !ok {
+			return nil, fmt.Errorf("%w: %q in table %q", ErrUnknownColumn, "token_id", tableName)
+		}
+		// Non-numeric token ids sort last, by their text
+		return []string{numericColumn("token_id"), "token_id", "id"}, nil
+	}
+	return nil, fmt.Errorf("unknown order %q", order)
+}
+
+// numericColumn returns an expression reading column as NUMERIC, or NULL when
+// it does not hold an unsigned integer, so stray values cannot fail the cast.
+// column must be a text column, as the token id and balance columns are.
+func numericColumn(column string) string {
+	return "CASE WHEN " + column + " ~ '^[0-9]+$' THEN CAST(" + column + " AS NUMERIC) END"
+}
+
+// buildDataQuery builds the query selecting one page of the items matching
+// where in order. Only fields are selected, or every column when fields is empty.
+func buildDataQuery(tableName string, fields []string, order AssetOrder, limit int, offset int, where Expr) (squirrel.SelectBuilder, error) {
+	predicate, err := compileWhere(tableName, where)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+
+	orderBy, err := orderClauses(tableName, order)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+
+	selected, err := selectColumns(tableName, fields)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
 	}
 
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	queryBuilder := psql.Select(selected...).From(tableName)
+	if predicate != nil {
+		queryBuilder = queryBuilder.Where(predicate)
+	}
+	queryBuilder = queryBuilder.OrderBy(orderBy...)
+
 	// Apply pagination
 	if limit > 0 {
 		queryBuilder = queryBuilder.Limit(uint64(limit))
@@ -49,141 +91,199 @@ func QueryWithDynamicFilter[T any](db *sql.DB, tableName string, limit int, offs
 	if offset > 0 {
 		queryBuilder = queryBuilder.Offset(uint64(offset))
 	}
+	return queryBuilder, nil
+}
 
-	query, args, err := queryBuilder.ToSql()
+// buildCountQueries builds the queries counting the items matching where and their distinct holders
+func buildCountQueries(tableName string, where Expr) (squirrel.SelectBuilder, squirrel.SelectBuilder, error) {
+	predicate, err := compileWhere(tableName, where)
 	if err != nil {
-		return nil, fmt.Errorf("error building SQL query: %w", err)
+		return squirrel.SelectBuilder{}, squirrel.SelectBuilder{}, err
 	}
 
-	rows, err := db.Query(query, args...)
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	countBuilder := psql.Select("COUNT(*)").From(tableName)
+	holderBuilder := psql.Select("COUNT(DISTINCT(owner))").From(tableName)
+	if predicate != nil {
+		countBuilder = countBuilder.Where(predicate)
+		holderBuilder = holderBuilder.Where(predicate)
+	}
+	return countBuilder, holderBuilder, nil
+}
+
+// buildExistsQuery builds the query checking whether any item matches where
+func buildExistsQuery(tableName string, where Expr) (string, []interface{}, error) {
+	predicate, err := compileWhere(tableName, where)
 	if err != nil {
-		return nil, fmt.Errorf("error executing query: %w", err)
+		return "", nil, err
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	innerBuilder := squirrel.Select("1").From(tableName).Limit(1)
+	if predicate != nil {
+		innerBuilder = innerBuilder.Where(predicate)
+	}
+
+	inner, args, err := innerBuilder.ToSql()
 	if err != nil {
-		return nil, fmt.Errorf("error getting columns: %w", err)
+		return "", nil, fmt.Errorf("error building exists query: %w", err)
 	}
 
-	var items []T
-	var item T
-	itemType := reflect.TypeOf(item)
-	if itemType.Kind() == reflect.Ptr {
-		itemType = itemType.Elem()
+	query, err := squirrel.Dollar.ReplacePlaceholders("SELECT EXISTS (" + inner + ")")
+	if err != nil {
+		return "", nil, fmt.Errorf("error building exists query: %w", err)
 	}
+	return query, args, nil
+}
 
-	// Create a map of DB column names to struct field indices
-	columnMap := make(map[string]int)
-	for i := 0; i < itemType.NumField(); i++ {
-		field := itemType.Field(i)
+// buildEstimateQuery builds the query estimating how many items match where
+// from the row estimate of the planner with EXPLAIN
+func buildEstimateQuery(tableName string, where Expr) (string, []interface{}, error) {
+	predicate, err := compileWhere(tableName, where)
+	if err != nil {
+		return "", nil, err
+	}
 
-		// Check for db tag first
-		dbTag := field.Tag.Get("db")
-		if dbTag != "" {
-			columnMap[dbTag] = i
-			continue
-		}
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	estimateBuilder := psql.Select("1").From(tableName)
+	if predicate != nil {
+		estimateBuilder = estimateBuilder.Where(predicate)
+	}
 
-		// Then check for json tag
-		jsonTag := field.Tag.Get("json")
-		if jsonTag != "" {
-			// Split the json tag to handle options like omitempty
-			tagParts := strings.Split(jsonTag, ",")
-			columnMap[toSnakeCase(tagParts[0])] = i
-			continue
-		}
+	query, args, err := estimateBuilder.ToSql()
+	if err != nil {
+		return "", nil, fmt.Errorf("error building estimate query: %w", err)
+	}
+	return "EXPLAIN (FORMAT JSON) " + query, args, nil
+}
 
-		// If no tags present, use field name in snake_case
-		columnMap[toSnakeCase(field.Name)] = i
-	}
-
-	for rows.Next() {
-		itemValue := reflect.New(itemType).Elem()
-		scanArgs := make([]interface{}, len(columns))
-
-		for i, colName := range columns {
-			if fieldIndex, ok := columnMap[colName]; ok {
-				field := itemValue.Field(fieldIndex)
-
-				// Handle null values for pointer fields
-				if field.Kind() == reflect.Ptr {
-					if field.IsNil() {
-						field.Set(reflect.New(field.Type().Elem()))
-					}
-					scanArgs[i] = field.Interface()
-				} else {
-					scanArgs[i] = field.Addr().Interface()
-				}
-			} else {
-				var placeholder interface{}
-				scanArgs[i] = &placeholder
-			}
-		}
+// QueryWithDynamicFilter retrieves a slice of items from the database
+//
+// Deprecated: use QueryWithFilters. The keys of filterConditions are now
+// checked against the table allowlist in the same way.
+func QueryWithDynamicFilter[T any](db *sql.DB, tableName string, limit int, offset int, filterConditions map[string][]string) ([]T, error) {
+	return QueryWithFilters[T](db, tableName, limit, offset, filtersFromConditions(filterConditions))
+}
 
-		if err := rows.Scan(scanArgs...); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
+// QueryWithFilters retrieves a page of items matching filters from a
+// registered collection table. Unknown tables and columns are rejected.
+func QueryWithFilters[T any](db *sql.DB, tableName string, limit int, offset int, filters []Filter) ([]T, error) {
+	queryBuilder, err := buildDataQuery(tableName, nil, OrderByCreatedAt, limit, offset, filtersExpr(filters))
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building SQL query: %w", err)
+	}
+	return queryRows[T](context.Background(), db, query, args)
+}
 
-		items = append(items, itemValue.Interface().(T))
+// queryer runs queries on a *sql.DB, or on a *sql.Tx so several statements
+// read the same snapshot
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// queryRows runs query and scans every row into a T. T is a struct, or a
+// pointer to one, whose fields are matched to columns by a cached scanner.
+func queryRows[T any](ctx context.Context, db queryer, query string, args []interface{}) ([]T, error) {
+	var item T
+	itemType := reflect.TypeOf(item)
+	pointer := itemType.Kind() == reflect.Ptr
+	if pointer {
+		itemType = itemType.Elem()
 	}
+	scanner := scannerFor(itemType)
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
 	}
+	defer rows.Close()
 
-	return items, nil
+	return scanAll[T](rows, scanner, pointer)
 }
 
 // CountItems counts the number of items in the database based on dynamic filters.
+//
+// Deprecated: use CountItemsWithFilters. The keys of filterConditions are now
+// checked against the table allowlist in the same way.
 func CountItemsWithFilter(db *sql.DB, tableName string, filterConditions map[string][]string) (int, int64, error) {
-	// Create a Squirrel query builder for counting items
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-	queryBuilder := psql.Select("COUNT(*)").From(tableName)
-
-	// Create a Squirrel query builder for counting items holder
-	psql2 := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-	holderQueryBuilder := psql2.Select("COUNT(DISTINCT(owner))").From(tableName)
-
-	// Apply dynamic filters
-	for column, values := range filterConditions {
-		if column == "created_at_from" {
-			fmt.Println("created_at_from", values[0])
-			queryBuilder = queryBuilder.Where(squirrel.GtOrEq{"created_at": values[0]})
-		} else if column == "created_at_to" {
-			fmt.Println("created_at_to", values[0])
-			queryBuilder = queryBuilder.Where(squirrel.LtOrEq{"created_at": values[0]})
-		} else if len(values) == 1 {
-			queryBuilder = queryBuilder.Where(squirrel.Eq{column: values[0]})
-		} else if len(values) > 1 {
-			queryBuilder = queryBuilder.Where(squirrel.Eq{column: values})
-		}
+	itemCount, holderCount, err := CountItemsWithFilters(db, tableName, filtersFromConditions(filterConditions))
+	return int(itemCount), holderCount, err
+}
+
+// CountItemsWithFilters counts the items matching filters in a registered
+// collection table and their distinct holders
+func CountItemsWithFilters(db *sql.DB, tableName string, filters []Filter) (int64, int64, error) {
+	countBuilder, holderBuilder, err := buildCountQueries(tableName, filtersExpr(filters))
+	if err != nil {
+		return 0, 0, err
 	}
 
 	// Convert the query to SQL
-	query, args, err := queryBuilder.ToSql()
+	query, args, err := countBuilder.ToSql()
 	if err != nil {
 		return 0, 0, err
 	}
 
-	holderQuery, holderArgs, err := holderQueryBuilder.ToSql()
+	holderQuery, holderArgs, err := holderBuilder.ToSql()
 	if err != nil {
 		return 0, 0, err
 	}
 
 	// Execute the query
-	var itemCount int
-	var holderCount int64
-
-	err = db.QueryRow(query, args...).Scan(&itemCount)
+	itemCount, err := queryCount(context.Background(), db, query, args)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	err = db.QueryRow(holderQuery, holderArgs...).Scan(&holderCount)
+	holderCount, err := queryCount(context.Background(), db, holderQuery, holderArgs)
 	if err != nil {
 		return 0, 0, err
 	}
 
 	return itemCount, holderCount, nil
 }
+
+// queryExists runs a query returning a single boolean
+func queryExists(ctx context.Context, db queryer, query string, args []interface{}) (bool, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// queryEstimate runs a query built by buildEstimateQuery. It returns -1 when
+// the plan has no estimate.
+func queryEstimate(ctx context.Context, db queryer, query string, args []interface{}) (int64, error) {
+	var planJson []byte
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&planJson); err != nil {
+		return 0, err
+	}
+
+	var plans []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(planJson, &plans); err != nil {
+		return 0, fmt.Errorf("error decoding query plan: %w", err)
+	}
+	if len(plans) == 0 {
+		return -1, nil
+	}
+	return int64(plans[0].Plan.PlanRows), nil
+}
+
+// queryCount runs a query returning a single count
+func queryCount(ctx context.Context, db queryer, query string, args []interface{}) (int64, error) {
+	var count int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
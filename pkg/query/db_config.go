@@ -4,13 +4,33 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type masterDbConfig struct {
-	localDb     *sql.DB
-	masterDbUrl string
-	useMasterDb bool
+	localDb         *sql.DB
+	masterDbUrl     string
+	useMasterDb     bool
+	httpClient      *HttpClient
+	defaultLimit    int
+	maxLimit        int
+	coalesce        *flightGroup
+	cache           Cache
+	cacheTTL        time.Duration
+	cacheGeneration atomic.Uint64 // Bumped by every invalidation
+	cacheMu         sync.RWMutex  // Shared by cache stores, exclusive to invalidations
+	logger          *slog.Logger
+	instrumentation Instrumentation
+	middlewares     []Middleware
+
+	approximateCountThreshold int64
+	checksumAddresses         bool
+	excludedOwners            []string
+	tokenIdChunkSize          int
 }
 
 // NewMasterDbConfig creates a new instance of masterDbConfig with validation
@@ -18,16 +38,58 @@ func NewMasterDbConfig(
 	localDb *sql.DB,
 	masterDbUrl string,
 	useMasterDb bool,
+	opts ...Option,
 ) (*masterDbConfig, error) {
 
 	if err := validateDbUrl(masterDbUrl); err != nil {
 		return nil, fmt.Errorf("invalid master URL: %w", err)
 	}
 
+	options := defaultConfigOptions()
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+
+	if options.defaultLimit > options.maxLimit {
+		return nil, fmt.Errorf("default limit %d exceeds max limit %d", options.defaultLimit, options.maxLimit)
+	}
+
+	client, err := options.buildHttpClient()
+	if err != nil {
+		return nil, fmt.Errorf("invalid http client configuration: %w", err)
+	}
+
+	var coalesce *flightGroup
+	if options.coalesceRequests {
+		coalesce = newFlightGroup()
+	}
+
 	return &masterDbConfig{
 		localDb:     localDb,
 		masterDbUrl: masterDbUrl,
 		useMasterDb: useMasterDb,
+		httpClient: newHttpClient(
+			masterDbUrl,
+			client,
+			options.userAgent,
+			newThrottle(options.requestsPerSecond, options.burst, options.maxInFlight),
+			options.compressAbove,
+		),
+		defaultLimit:    options.defaultLimit,
+		maxLimit:        options.maxLimit,
+		coalesce:        coalesce,
+		cache:           options.cache,
+		cacheTTL:        options.cacheTTL,
+		logger:          options.logger,
+		instrumentation: options.instrumentation,
+		middlewares:     options.middlewares,
+
+		approximateCountThreshold: options.approximateCount,
+		checksumAddresses:         options.checksumAddresses,
+		excludedOwners:            options.excludedOwners,
+		tokenIdChunkSize:          options.tokenIdChunkSize,
 	}, nil
 }
 
@@ -36,6 +98,47 @@ func (c *masterDbConfig) CreateQueryBuilder() AssetQueryBuilder {
 	return NewAssetQueryBuilder(c)
 }
 
+// InvalidateChain drops every cached result and lookup of the chain. Results
+// of queries in flight at the time are returned to their callers but not cached.
+func (c *masterDbConfig) InvalidateChain(chainId int32) {
+	c.invalidate(chainCacheTag(chainId))
+}
+
+// InvalidateCollection drops every cached result and lookup of the collection
+func (c *masterDbConfig) InvalidateCollection(chainId int32, collectionId string) {
+	c.invalidate(collectionCacheTag(chainId, collectionId))
+}
+
+// invalidate starts a new cache generation and drops the values of tag. A
+// store racing it either lands first and is dropped, or sees the new
+// generation and is skipped.
+func (c *masterDbConfig) invalidate(tag string) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	c.cacheGeneration.Add(1)
+	if c.cache != nil {
+		c.cache.Invalidate(tag)
+	}
+}
+
+// storeCached caches value under key unless the cache was invalidated since
+// generation was loaded
+func (c *masterDbConfig) storeCached(generation uint64, key string, value any, ttl time.Duration, tags []string) {
+	c.cacheMu.RLock()
+	defer c.cacheMu.RUnlock()
+
+	if c.cacheGeneration.Load() == generation {
+		c.cache.Set(key, value, ttl, tags...)
+	}
+}
+
+// ThrottleStats returns the time master requests spent waiting on the rate
+// limiter and the in-flight cap
+func (c *masterDbConfig) ThrottleStats() ThrottleStats {
+	return c.httpClient.ThrottleStats()
+}
+
 // validateDbUrl checks if the provided URL is valid
 func validateDbUrl(dbUrl string) error {
 	if dbUrl == "" {
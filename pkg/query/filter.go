@@ -0,0 +1,207 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"asset-query/pkg/models"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUnknownTable is returned when a query targets a table that is not a registered collection table
+	ErrUnknownTable = errors.New("unknown table")
+	// ErrUnknownColumn is returned when a filter references a column missing from the table allowlist
+	ErrUnknownColumn = errors.New("unknown column")
+	// ErrInvalidFilter is returned when a filter has an unknown operator or the wrong number of values
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// FilterOp is the comparison a Filter applies to its column
+type FilterOp string
+
+const (
+	FilterEq    FilterOp = "eq"   // Column equals the single value
+	FilterNe    FilterOp = "ne"   // Column differs from the single value
+	FilterIn    FilterOp = "in"   // Column equals one of the values
+	FilterNotIn FilterOp = "nin"  // Column equals none of the values, or is NULL
+	FilterGt    FilterOp = "gt"   // Column is greater than the single value
+	FilterGte   FilterOp = "gte"  // Column is greater than or equal to the single value
+	FilterLt    FilterOp = "lt"   // Column is less than the single value
+	FilterLte   FilterOp = "lte"  // Column is less than or equal to the single value
+	FilterLike  FilterOp = "like" // Column matches the single SQL LIKE pattern
+)
+
+// Filter is a typed condition on one column of a collection table, and the
+// leaf of filter expressions. Values are always bound as query arguments, and
+// Column is checked against the table allowlist before any SQL is built.
+type Filter struct {
+	Column string   `json:"column"`
+	Op     FilterOp `json:"op"`
+	Values []string `json:"values"`
+	// CaseInsensitive compares lower(Column) with the lowercased values, as
+	// for hex addresses. Plain indexes on Column are not used; an index on
+	// lower(Column) is, see migrations/0001_lower_address_indexes.sql.
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+	// Numeric compares Column and the values as unsigned integers of up to 256
+	// bits, as for token ids stored as text. Rows whose Column is not an
+	// unsigned integer never match. Plain indexes on Column are not used.
+	Numeric bool `json:"numeric,omitempty"`
+}
+
+// tableColumns is the column allowlist of every registered collection table,
+// derived from the asset models the rows are scanned into
+var tableColumns = map[string]map[string]struct{}{
+	"erc_721_collection_assets":  columnSet(reflect.TypeOf(models.Erc721CollectionAsset{})),
+	"erc_1155_collection_assets": columnSet(reflect.TypeOf(models.Erc1155CollectionAsset{})),
+	"erc_20_collection_assets":   columnSet(reflect.TypeOf(models.Erc20CollectionAsset{})),
+}
+
+func columnSet(modelType reflect.Type) map[string]struct{} {
+	columns := make(map[string]struct{})
+	for _, column := range scannerFor(modelType).columns() {
+		columns[column] = struct{}{}
+	}
+	return columns
+}
+
+// validateTable checks that tableName is a registered collection table
+func validateTable(tableName string) (map[string]struct{}, error) {
+	columns, ok := tableColumns[tableName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTable, tableName)
+	}
+	return columns, nil
+}
+
+// selectColumns validates fields against the allowlist of tableName and
+// returns the columns to select, or * when fields is empty
+func selectColumns(tableName string, fields []string) ([]string, error) {
+	columns, err := validateTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return []string{"*"}, nil
+	}
+
+	for _, field := range fields {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("%w: %q in table %q", ErrUnknownColumn, field, tableName)
+		}
+	}
+	return fields, nil
+}
+
+// sqlizer implements Expr.
+func (f Filter) sqlizer(tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error) {
+	if _, ok := columns[f.Column]; !ok {
+		return nil, fmt.Errorf("%w: %q in table %q", ErrUnknownColumn, f.Column, tableName)
+	}
+
+	column, values := f.Column, f.Values
+	if f.Numeric {
+		column = numericColumn(column)
+		for _, value := range values {
+			if _, err := models.ParseUint256(value); err != nil {
+				return nil, fmt.Errorf("%w: %s on %q: %v", ErrInvalidFilter, f.Op, f.Column, err)
+			}
+		}
+	}
+	if f.CaseInsensitive {
+		column = "lower(" + column + ")"
+		values = make([]string, len(f.Values))
+		for i, value := range f.Values {
+			values[i] = strings.ToLower(value)
+		}
+	}
+
+	if f.Op == FilterIn || f.Op == FilterNotIn {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%w: %s on %q takes at least one value", ErrInvalidFilter, f.Op, f.Column)
+		}
+
+		// The values are bound as one array, so lists of any length use a
+		// single parameter and share one statement text
+		array := "?"
+		if f.Numeric {
+			array = "CAST(? AS NUMERIC[])"
+		}
+		if f.Op == FilterNotIn {
+			// <> ALL is NULL rather than true for a NULL column
+			return squirrel.Expr("("+f.Column+" IS NULL OR "+column+" <> ALL("+array+"))", pq.Array(values)), nil
+		}
+		return squirrel.Expr(column+" = ANY("+array+")", pq.Array(values)), nil
+	}
+
+	if len(values) != 1 {
+		return nil, fmt.Errorf("%w: %s on %q takes exactly one value", ErrInvalidFilter, f.Op, f.Column)
+	}
+	value := values[0]
+
+	switch f.Op {
+	case FilterEq:
+		return squirrel.Eq{column: value}, nil
+	case FilterNe:
+		return squirrel.NotEq{column: value}, nil
+	case FilterGt:
+		return squirrel.Gt{column: value}, nil
+	case FilterGte:
+		return squirrel.GtOrEq{column: value}, nil
+	case FilterLt:
+		return squirrel.Lt{column: value}, nil
+	case FilterLte:
+		return squirrel.LtOrEq{column: value}, nil
+	case FilterLike:
+		return squirrel.Like{column: value}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, f.Op)
+}
+
+// filtersExpr combines filters with AND
+func filtersExpr(filters []Filter) AndExpr {
+	exprs := make(AndExpr, len(filters))
+	for i, filter := range filters {
+		exprs[i] = filter
+	}
+	return exprs
+}
+
+// filtersFromConditions converts the legacy map of dynamic filter conditions,
+// where created_at_from, created_at_to, updated_at_from and the exclusive
+// updated_at_to bound created_at and updated_at, to typed filters
+// ordered by column so identical conditions always produce the same SQL
+func filtersFromConditions(filterConditions map[string][]string) []Filter {
+	columns := make([]string, 0, len(filterConditions))
+	for column := range filterConditions {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	filters := make([]Filter, 0, len(columns))
+	for _, column := range columns {
+		values := filterConditions[column]
+		switch {
+		case column == "created_at_from" && len(values) > 0:
+			filters = append(filters, Filter{Column: "created_at", Op: FilterGte, Values: values[:1]})
+		case column == "created_at_to" && len(values) > 0:
+			filters = append(filters, Filter{Column: "created_at", Op: FilterLte, Values: values[:1]})
+		case column == "updated_at_from" && len(values) > 0:
+			filters = append(filters, Filter{Column: "updated_at", Op: FilterGte, Values: values[:1]})
+		case column == "updated_at_to" && len(values) > 0:
+			filters = append(filters, Filter{Column: "updated_at", Op: FilterLt, Values: values[:1]})
+		case len(values) == 1:
+			filters = append(filters, Filter{Column: column, Op: FilterEq, Values: values})
+		case len(values) > 1:
+			filters = append(filters, Filter{Column: column, Op: FilterIn, Values: values})
+		}
+	}
+	return filters
+}
@@ -0,0 +1,208 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// Expr is a boolean filter expression over the columns of a collection table.
+// Filter is the leaf expression; And, Or and Not combine expressions.
+type Expr interface {
+	// sqlizer validates the expression against the allowlist of tableName
+	// and compiles it to a squirrel predicate
+	sqlizer(tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error)
+}
+
+// AndExpr matches when every expression matches. An empty AndExpr matches everything.
+type AndExpr []Expr
+
+// OrExpr matches when at least one expression matches. An empty OrExpr matches nothing.
+type OrExpr []Expr
+
+// NotExpr matches when its expression does not match
+type NotExpr struct {
+	Expr Expr
+}
+
+// And combines exprs with AND
+func And(exprs ...Expr) AndExpr {
+	return AndExpr(exprs)
+}
+
+// Or combines exprs with OR
+func Or(exprs ...Expr) OrExpr {
+	return OrExpr(exprs)
+}
+
+// Not negates expr
+func Not(expr Expr) NotExpr {
+	return NotExpr{Expr: expr}
+}
+
+// Eq matches rows where column equals value
+func Eq(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterEq, Values: []string{value}}
+}
+
+// EqFold matches rows where column equals value ignoring case
+func EqFold(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterEq, Values: []string{value}, CaseInsensitive: true}
+}
+
+// Ne matches rows where column differs from value
+func Ne(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterNe, Values: []string{value}}
+}
+
+// In matches rows where column equals one of values
+func In(column string, values ...string) Filter {
+	return Filter{Column: column, Op: FilterIn, Values: values}
+}
+
+// InFold matches rows where column equals one of values ignoring case
+func InFold(column string, values ...string) Filter {
+	return Filter{Column: column, Op: FilterIn, Values: values, CaseInsensitive: true}
+}
+
+// NotIn matches rows where column equals none of values, or is NULL
+func NotIn(column string, values ...string) Filter {
+	return Filter{Column: column, Op: FilterNotIn, Values: values}
+}
+
+// NotInFold matches rows where column equals none of values ignoring case,
+// or is NULL
+func NotInFold(column string, values ...string) Filter {
+	return Filter{Column: column, Op: FilterNotIn, Values: values, CaseInsensitive: true}
+}
+
+// Gt matches rows where column is greater than value
+func Gt(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterGt, Values: []string{value}}
+}
+
+// Gte matches rows where column is greater than or equal to value
+func Gte(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterGte, Values: []string{value}}
+}
+
+// Lt matches rows where column is less than value
+func Lt(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterLt, Values: []string{value}}
+}
+
+// Lte matches rows where column is less than or equal to value
+func Lte(column string, value string) Filter {
+	return Filter{Column: column, Op: FilterLte, Values: []string{value}}
+}
+
+// Like matches rows where column matches the SQL LIKE pattern
+func Like(column string, pattern string) Filter {
+	return Filter{Column: column, Op: FilterLike, Values: []string{pattern}}
+}
+
+func (e AndExpr) sqlizer(tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error) {
+	and := make(squirrel.And, 0, len(e))
+	for _, expr := range e {
+		sqlizer, err := compileExpr(expr, tableName, columns)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, sqlizer)
+	}
+	return and, nil
+}
+
+func (e OrExpr) sqlizer(tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error) {
+	or := make(squirrel.Or, 0, len(e))
+	for _, expr := range e {
+		sqlizer, err := compileExpr(expr, tableName, columns)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, sqlizer)
+	}
+	return or, nil
+}
+
+func (e NotExpr) sqlizer(tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error) {
+	inner, err := compileExpr(e.Expr, tableName, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err := inner.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return squirrel.Expr("NOT ("+query+")", args...), nil
+}
+
+// compileExpr compiles expr, rejecting nil expressions
+func compileExpr(expr Expr, tableName string, columns map[string]struct{}) (squirrel.Sqlizer, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("%w: nil expression", ErrInvalidFilter)
+	}
+	return expr.sqlizer(tableName, columns)
+}
+
+// cloneExpr returns a deep copy of expr
+func cloneExpr(expr Expr) Expr {
+	switch expr := expr.(type) {
+	case Filter:
+		expr.Values = slices.Clone(expr.Values)
+		return expr
+	case AndExpr:
+		return AndExpr(cloneExprs(expr))
+	case OrExpr:
+		return OrExpr(cloneExprs(expr))
+	case NotExpr:
+		return NotExpr{Expr: cloneExpr(expr.Expr)}
+	}
+	return expr
+}
+
+func cloneExprs(exprs []Expr) []Expr {
+	if exprs == nil {
+		return nil
+	}
+	cloned := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		cloned[i] = cloneExpr(expr)
+	}
+	return cloned
+}
+
+// compileWhere validates tableName and where, and compiles where to a
+// predicate. It returns nil when where does not restrict the query.
+func compileWhere(tableName string, where Expr) (squirrel.Sqlizer, error) {
+	columns, err := validateTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if where == nil {
+		return nil, nil
+	}
+	if and, ok := where.(AndExpr); ok && len(and) == 0 {
+		return nil, nil
+	}
+	return where.sqlizer(tableName, columns)
+}
+
+// MarshalJSON encodes the expression as {"and": [...]}
+func (e AndExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string][]Expr{"and": e})
+}
+
+// MarshalJSON encodes the expression as {"or": [...]}
+func (e OrExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string][]Expr{"or": e})
+}
+
+// MarshalJSON encodes the expression as {"not": {...}}
+func (e NotExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]Expr{"not": e.Expr})
+}
@@ -0,0 +1,151 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestFilterSqlizer(t *testing.T) {
+	const numericTokenId = "CASE WHEN token_id ~ '^[0-9]+$' THEN CAST(token_id AS NUMERIC) END"
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			"eq",
+			Filter{Column: "token_id", Op: FilterEq, Values: []string{"1"}},
+			"token_id = ?", []interface{}{"1"},
+		},
+		{
+			"ne",
+			Filter{Column: "token_id", Op: FilterNe, Values: []string{"1"}},
+			"token_id <> ?", []interface{}{"1"},
+		},
+		{
+			"lt",
+			Filter{Column: "updated_at", Op: FilterLt, Values: []string{"2025-01-01T00:00:00Z"}},
+			"updated_at < ?", []interface{}{"2025-01-01T00:00:00Z"},
+		},
+		{
+			"like",
+			Filter{Column: "signature", Op: FilterLike, Values: []string{"0x%"}},
+			"signature LIKE ?", []interface{}{"0x%"},
+		},
+		{
+			"in binds one array",
+			Filter{Column: "token_id", Op: FilterIn, Values: []string{"1", "2", "3"}},
+			"token_id = ANY(?)", []interface{}{pq.Array([]string{"1", "2", "3"})},
+		},
+		{
+			"not in keeps NULL",
+			Filter{Column: "owner", Op: FilterNotIn, Values: []string{"0xa", "0xb"}},
+			"(owner IS NULL OR owner <> ALL(?))", []interface{}{pq.Array([]string{"0xa", "0xb"})},
+		},
+		{
+			"case insensitive eq",
+			Filter{Column: "owner", Op: FilterEq, Values: []string{"0xAbC"}, CaseInsensitive: true},
+			"lower(owner) = ?", []interface{}{"0xabc"},
+		},
+		{
+			"case insensitive in",
+			Filter{Column: "collection_id", Op: FilterIn, Values: []string{"1:0xAB", "1:0xcd"}, CaseInsensitive: true},
+			"lower(collection_id) = ANY(?)", []interface{}{pq.Array([]string{"1:0xab", "1:0xcd"})},
+		},
+		{
+			"case insensitive not in",
+			Filter{Column: "owner", Op: FilterNotIn, Values: []string{"0xAB"}, CaseInsensitive: true},
+			"(owner IS NULL OR lower(owner) <> ALL(?))", []interface{}{pq.Array([]string{"0xab"})},
+		},
+		{
+			"numeric gte",
+			Filter{Column: "token_id", Op: FilterGte, Values: []string{"10"}, Numeric: true},
+			numericTokenId + " >= ?", []interface{}{"10"},
+		},
+		{
+			"numeric lte",
+			Filter{Column: "token_id", Op: FilterLte, Values: []string{"99"}, Numeric: true},
+			numericTokenId + " <= ?", []interface{}{"99"},
+		},
+		{
+			"numeric in",
+			Filter{Column: "token_id", Op: FilterIn, Values: []string{"1", "2"}, Numeric: true},
+			numericTokenId + " = ANY(CAST(? AS NUMERIC[]))", []interface{}{pq.Array([]string{"1", "2"})},
+		},
+		{
+			"numeric not in",
+			Filter{Column: "token_id", Op: FilterNotIn, Values: []string{"1"}, Numeric: true},
+			"(token_id IS NULL OR " + numericTokenId + " <> ALL(CAST(? AS NUMERIC[])))", []interface{}{pq.Array([]string{"1"})},
+		},
+	}
+
+	columns := tableColumns["erc_721_collection_assets"]
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlizer, err := test.filter.sqlizer("erc_721_collection_assets", columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sql, args, err := sqlizer.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != test.wantSQL {
+				t.Errorf("got SQL %q, want %q", sql, test.wantSQL)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("got args %#v, want %#v", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterSqlizerKeepsValues(t *testing.T) {
+	filter := Filter{Column: "owner", Op: FilterIn, Values: []string{"0xAB"}, CaseInsensitive: true}
+	if _, err := filter.sqlizer("erc_721_collection_assets", tableColumns["erc_721_collection_assets"]); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Values[0] != "0xAB" {
+		t.Errorf("filter values modified to %q", filter.Values)
+	}
+}
+
+func TestFilterSqlizerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr error
+	}{
+		{"unknown column", Filter{Column: "balance", Op: FilterEq, Values: []string{"1"}}, ErrUnknownColumn},
+		{"injected column", Filter{Column: "token_id; DROP TABLE x", Op: FilterEq, Values: []string{"1"}}, ErrUnknownColumn},
+		{"unknown operator", Filter{Column: "token_id", Op: "between", Values: []string{"1"}}, ErrInvalidFilter},
+		{"in without values", Filter{Column: "token_id", Op: FilterIn}, ErrInvalidFilter},
+		{"not in without values", Filter{Column: "token_id", Op: FilterNotIn, Values: []string{}}, ErrInvalidFilter},
+		{"eq with two values", Filter{Column: "token_id", Op: FilterEq, Values: []string{"1", "2"}}, ErrInvalidFilter},
+		{"gt without values", Filter{Column: "token_id", Op: FilterGt}, ErrInvalidFilter},
+		{"numeric text", Filter{Column: "token_id", Op: FilterGte, Values: []string{"abc"}, Numeric: true}, ErrInvalidFilter},
+		{"numeric negative", Filter{Column: "token_id", Op: FilterLt, Values: []string{"-1"}, Numeric: true}, ErrInvalidFilter},
+		{"numeric hex", Filter{Column: "token_id", Op: FilterIn, Values: []string{"1", "0x10"}, Numeric: true}, ErrInvalidFilter},
+		{
+			"numeric overflow",
+			Filter{Column: "token_id", Op: FilterEq, Numeric: true,
+				Values: []string{"115792089237316195423570985008687907853269984665640564039457584007913129639936"}},
+			ErrInvalidFilter,
+		},
+	}
+
+	columns := tableColumns["erc_721_collection_assets"]
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.filter.sqlizer("erc_721_collection_assets", columns)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
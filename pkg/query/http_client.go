@@ -2,63 +2,119 @@ package query
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 )
 
 type HttpClient struct {
-	client  *http.Client
-	baseURL string
+	client    *http.Client
+	baseURL   string
+	userAgent string
+	throttle  *throttle
+	// compressAbove is the request body size from which bodies are sent
+	// gzip-compressed, or 0 to never compress
+	compressAbove int
 }
 
 func NewHttpClient(baseURL string) *HttpClient {
+	return newHttpClient(baseURL, &http.Client{Timeout: defaultHttpTimeout}, "", newThrottle(0, 0, 0), 0)
+}
+
+func newHttpClient(baseURL string, client *http.Client, userAgent string, throttle *throttle, compressAbove int) *HttpClient {
 	return &HttpClient{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		baseURL: baseURL,
+		client:        client,
+		baseURL:       baseURL,
+		userAgent:     userAgent,
+		throttle:      throttle,
+		compressAbove: compressAbove,
 	}
 }
 
+// ThrottleStats returns the time requests of this client spent throttled
+func (c *HttpClient) ThrottleStats() ThrottleStats {
+	return c.throttle.stats()
+}
+
 func (c *HttpClient) DoRequest(ctx context.Context, method, path string, body interface{}, response interface{}) error {
+	_, err := c.doRequest(ctx, method, path, body, response)
+	return err
+}
+
+// doRequest is DoRequest that also returns the HTTP status code of the
+// response, or 0 when no response was received
+func (c *HttpClient) doRequest(ctx context.Context, method, path string, body interface{}, response interface{}) (int, error) {
 	url := fmt.Sprintf("%s%s", c.baseURL, path)
 
+	release, err := c.throttle.acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("request throttled: %w", err)
+	}
+	defer release()
+
 	var bodyReader io.Reader
+	compressed := false
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("failed to marshal request body: %w", err)
+			return 0, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+
+		if c.compressAbove > 0 && len(jsonBody) >= c.compressAbove {
+			jsonBody, err = gzipBody(jsonBody)
+			if err != nil {
+				return 0, fmt.Errorf("failed to compress request body: %w", err)
+			}
+			compressed = true
 		}
 		bodyReader = bytes.NewReader(jsonBody)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+		return 0, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return resp.StatusCode, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	if response != nil {
 		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			return fmt.Errorf("failed to decode response: %w", err)
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
 		}
 	}
 
-	return nil
+	return resp.StatusCode, nil
+}
+
+// gzipBody compresses a request body
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,211 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	masterDbCommon "github.com/u2u-labs/go-layerg-common/masterdb"
+)
+
+// collectionTables maps each collection type to the local table holding its assets
+var collectionTables = map[masterDbCommon.CollectionType]string{
+	masterDbCommon.CollectionTypeERC721:  "erc_721_collection_assets",
+	masterDbCommon.CollectionTypeERC1155: "erc_1155_collection_assets",
+	masterDbCommon.CollectionTypeERC20:   "erc_20_collection_assets",
+}
+
+// Statement is a SQL statement with its positional arguments
+type Statement struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+// SQLStatements are the statements a query runs against the local database
+type SQLStatements struct {
+	Table    string    `json:"table"`
+	Data     Statement `json:"data"`     // Selects the requested page, plus one row without total
+	Count    Statement `json:"count"`    // Counts every matching item
+	Holders  Statement `json:"holders"`  // Counts the distinct owners of the matching items
+	Exists   Statement `json:"exists"`   // Checks whether any item matches
+	Estimate Statement `json:"estimate"` // Estimates the count from the query plan
+}
+
+// localStatements builds the statements running spec against tableName
+func localStatements(spec QuerySpec, tableName string) (SQLStatements, error) {
+	where := spec.where()
+
+	// Without total, one extra row tells whether a next page exists
+	limit := spec.Limit
+	if spec.WithoutTotal {
+		limit++
+	}
+
+	dataBuilder, err := buildDataQuery(tableName, spec.Fields, spec.Order, limit, spec.Offset, where)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	dataQuery, dataArgs, err := dataBuilder.ToSql()
+	if err != nil {
+		return SQLStatements{}, fmt.Errorf("error building SQL query: %w", err)
+	}
+
+	countBuilder, holderBuilder, err := buildCountQueries(tableName, where)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	countQuery, countArgs, err := countBuilder.ToSql()
+	if err != nil {
+		return SQLStatements{}, fmt.Errorf("error building count query: %w", err)
+	}
+
+	holderQuery, holderArgs, err := holderBuilder.ToSql()
+	if err != nil {
+		return SQLStatements{}, fmt.Errorf("error building holder count query: %w", err)
+	}
+
+	existsQuery, existsArgs, err := buildExistsQuery(tableName, where)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	estimateQuery, estimateArgs, err := buildEstimateQuery(tableName, where)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	return SQLStatements{
+		Table:    tableName,
+		Data:     Statement{SQL: dataQuery, Args: dataArgs},
+		Count:    Statement{SQL: countQuery, Args: countArgs},
+		Holders:  Statement{SQL: holderQuery, Args: holderArgs},
+		Exists:   Statement{SQL: existsQuery, Args: existsArgs},
+		Estimate: Statement{SQL: estimateQuery, Args: estimateArgs},
+	}, nil
+}
+
+// toSQL resolves the collection table of spec and builds its statements
+func (b *assetQueryBuilderParam) toSQL(ctx context.Context, spec QuerySpec) (SQLStatements, error) {
+	collections, err := b.getSpecCollections(ctx, spec)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	spec, err = resolveBalanceFilters(spec, collections)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	collectionType, err := specCollectionType(spec, collections)
+	if err != nil {
+		return SQLStatements{}, err
+	}
+
+	tableName, ok := collectionTables[collectionType]
+	if !ok {
+		return SQLStatements{}, fmt.Errorf("unsupported collection type %q", collectionType)
+	}
+	return localStatements(spec, tableName)
+}
+
+// errInspected ends the middleware chain of an inspection once the query has
+// been built, in place of a result
+var errInspected = errors.New("query inspected, not executed")
+
+// inspect runs the middlewares around build instead of around the execution
+// of the query, so build sees the spec rewrites they make, such as forced
+// filters. The middlewares see the query fail with errInspected. A middleware
+// that answers the query without calling next leaves nothing to inspect.
+func inspect[T any](ctx context.Context, b *assetQueryBuilderParam, build func(ctx context.Context, spec QuerySpec) (T, error)) (T, error) {
+	var output T
+	spec, err := b.validSpec()
+	if err != nil {
+		return output, err
+	}
+
+	built := false
+	handler := chainMiddleware(b.config.middlewares, func(ctx context.Context, spec QuerySpec) (any, error) {
+		result, err := build(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		output, built = result, true
+		return nil, errInspected
+	})
+
+	_, err = handler(ctx, spec)
+	switch {
+	case built:
+		return output, nil
+	case err == nil || errors.Is(err, errInspected):
+		return output, errors.New("a middleware answered the query without running it")
+	}
+	return output, err
+}
+
+// ToSQL returns the data and count statements the query runs against the
+// local database, after the middlewares have rewritten its spec. Resolving the
+// collection table may require a collection type lookup on the master.
+func (b *assetQueryBuilderParam) ToSQL() (SQLStatements, error) {
+	return b.ToSQLContext(context.Background())
+}
+
+// ToSQLContext is like ToSQL but stops waiting on the master collection
+// lookup once ctx is done.
+func (b *assetQueryBuilderParam) ToSQLContext(ctx context.Context) (SQLStatements, error) {
+	return inspect(ctx, b, b.toSQL)
+}
+
+// ToRequest returns the JSON body the query POSTs to the master /query-builder
+// endpoint, after the middlewares have rewritten its spec. Balance bounds
+// require a lookup of the collection decimals on the master.
+func (b *assetQueryBuilderParam) ToRequest() ([]byte, error) {
+	return b.ToRequestContext(context.Background())
+}
+
+// ToRequestContext is like ToRequest but stops waiting on the master
+// collection lookup once ctx is done.
+func (b *assetQueryBuilderParam) ToRequestContext(ctx context.Context) ([]byte, error) {
+	return inspect(ctx, b, b.toRequest)
+}
+
+// toRequest resolves the balance bounds of spec and encodes its request body
+func (b *assetQueryBuilderParam) toRequest(ctx context.Context, spec QuerySpec) ([]byte, error) {
+	if spec.MinBalance != "" || spec.MaxBalance != "" {
+		collections, err := b.getSpecCollections(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		spec, err = resolveBalanceFilters(spec, collections)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(spec.requestBody())
+}
+
+// Explain runs EXPLAIN (ANALYZE, FORMAT JSON) for the data statement ToSQL
+// returns on the local database and returns the plan. ANALYZE executes the
+// statement, so this costs as much as running the query itself.
+func (b *assetQueryBuilderParam) Explain(ctx context.Context) (json.RawMessage, error) {
+	if b.config.localDb == nil {
+		return nil, errors.New("explain requires a local database")
+	}
+
+	statements, err := inspect(ctx, b, b.toSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []byte
+	query := "EXPLAIN (ANALYZE, FORMAT JSON) " + statements.Data.SQL
+	if err := b.config.localDb.QueryRowContext(ctx, query, statements.Data.Args...).Scan(&plan); err != nil {
+		return nil, fmt.Errorf("error explaining query: %w", err)
+	}
+
+	return json.RawMessage(plan), nil
+}
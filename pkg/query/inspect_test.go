@@ -0,0 +1,129 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const inspectCollectionId = "1:0x0091bd12166d29539db6bb37fb79670779abf266"
+
+// tenantMiddleware forces every query onto the assets of one owner
+func tenantMiddleware(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error) {
+	spec.Owner = "0x821dab5c6fffd8183d4e3e4a5c1725c847c36789"
+	return next(ctx, spec)
+}
+
+// inspectBuilder returns a query of the inspected collection on a config
+// with middlewares and a fake master
+func inspectBuilder(t *testing.T, middlewares ...Middleware) AssetQueryFunction {
+	t.Helper()
+
+	server := httptest.NewServer(&fakeMaster{})
+	t.Cleanup(server.Close)
+
+	config, err := NewMasterDbConfig(nil, server.URL, true, WithMiddleware(middlewares...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.CreateQueryBuilder().WithChainId(1).WithCollectionId(inspectCollectionId).Build()
+}
+
+func TestToSQLAppliesMiddlewares(t *testing.T) {
+	statements, err := inspectBuilder(t, tenantMiddleware).ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, statement := range map[string]Statement{
+		"data":    statements.Data,
+		"count":   statements.Count,
+		"holders": statements.Holders,
+		"exists":  statements.Exists,
+	} {
+		if !strings.Contains(statement.SQL, "lower(owner) = $") {
+			t.Errorf("%s statement %q misses the owner forced by the middleware", name, statement.SQL)
+		}
+	}
+}
+
+func TestToRequestAppliesMiddlewares(t *testing.T) {
+	body, err := inspectBuilder(t, tenantMiddleware).ToRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request struct {
+		Owner string `json:"owner"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Owner != "0x821dab5c6fffd8183d4e3e4a5c1725c847c36789" {
+		t.Errorf("got owner %q in %s, want the one forced by the middleware", request.Owner, body)
+	}
+}
+
+func TestInspectMiddlewareResults(t *testing.T) {
+	denied := errors.New("tenant not allowed")
+
+	tests := []struct {
+		name       string
+		middleware Middleware
+		wantErr    string
+	}{
+		{
+			"swallows the inspection error",
+			func(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error) {
+				next(ctx, spec)
+				return Pagination[struct{}]{}, nil
+			},
+			"",
+		},
+		{
+			"wraps the inspection error",
+			func(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error) {
+				result, err := next(ctx, spec)
+				if err != nil {
+					return nil, errors.New("query failed: " + err.Error())
+				}
+				return result, nil
+			},
+			"",
+		},
+		{
+			"rejects the query",
+			func(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error) {
+				return nil, denied
+			},
+			denied.Error(),
+		},
+		{
+			"answers without next",
+			func(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error) {
+				return Pagination[struct{}]{}, nil
+			},
+			"without running it",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := inspectBuilder(t, test.middleware)
+			_, sqlErr := query.ToSQL()
+			_, requestErr := query.ToRequest()
+
+			for _, err := range []error{sqlErr, requestErr} {
+				switch {
+				case test.wantErr == "" && err != nil:
+					t.Errorf("got error %v, want none", err)
+				case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+					t.Errorf("got error %v, want one containing %q", err, test.wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"time"
+)
+
+// Operation identifies an instrumented step of a query execution
+type Operation string
+
+const (
+	OperationCollectionType Operation = "collection_type" // Collection lookup, including its type and decimals
+	OperationCount          Operation = "count"           // Local count query
+	OperationExists         Operation = "exists"          // Local exists query
+	OperationData           Operation = "data"            // Local page query
+	OperationMasterRequest  Operation = "master_request"  // HTTP call to the master
+)
+
+// SpanInfo describes an operation when it starts
+type SpanInfo struct {
+	Operation      Operation `json:"operation"`
+	ChainId        int32     `json:"chainId"`
+	CollectionId   string    `json:"collectionId"`
+	Source         string    `json:"source"`         // "local" or "master"
+	SQL            string    `json:"sql"`            // SQL text with placeholders, local queries only
+	SQLFingerprint string    `json:"sqlFingerprint"` // Stable hash of SQL ignoring the number of IN values
+	Method         string    `json:"method"`         // HTTP method, master requests only
+	Path           string    `json:"path"`           // HTTP path, master requests only
+}
+
+// SpanResult describes an operation when it ends
+type SpanResult struct {
+	Rows       int64         `json:"rows"`       // Rows returned or counted
+	HttpStatus int           `json:"httpStatus"` // HTTP status code, 0 when no response was received
+	Duration   time.Duration `json:"duration"`
+	Err        error         `json:"-"`
+}
+
+// Instrumentation receives a span for every step of a query execution, so it
+// can be bridged to metrics or tracing systems. Implementations must be safe
+// for concurrent use.
+type Instrumentation interface {
+	// StartSpan is called when an operation starts. The returned context is
+	// used for the operation, so spans of nested operations can be linked.
+	StartSpan(ctx context.Context, info SpanInfo) (context.Context, Span)
+}
+
+// Span is an operation started by Instrumentation.StartSpan
+type Span interface {
+	// End is called exactly once when the operation completes
+	End(result SpanResult)
+}
+
+type noopInstrumentation struct{}
+
+func (noopInstrumentation) StartSpan(ctx context.Context, _ SpanInfo) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(SpanResult) {}
+
+// span wraps a Span with the start time of its operation
+type span struct {
+	Span
+	start time.Time
+}
+
+func startSpan(ctx context.Context, instrumentation Instrumentation, info SpanInfo) (context.Context, *span) {
+	if info.SQL != "" {
+		info.SQLFingerprint = sqlFingerprint(info.SQL)
+	}
+	ctx, s := instrumentation.StartSpan(ctx, info)
+	return ctx, &span{Span: s, start: time.Now()}
+}
+
+// end completes the span, filling in its duration
+func (s *span) end(result SpanResult) {
+	result.Duration = time.Since(s.start)
+	s.Span.End(result)
+}
+
+var placeholderList = regexp.MustCompile(`\$\d+(\s*,\s*\$\d+)*`)
+
+// sqlFingerprint hashes query after collapsing placeholder lists, so the same
+// statement with a different number of IN values shares a fingerprint
+func sqlFingerprint(query string) string {
+	normalized := placeholderList.ReplaceAllString(query, "?")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,29 @@
+package query
+
+import "context"
+
+// QueryHandler executes an asset query. Its result depends on spec.Mode: a
+// Pagination of the assets of the collection type for QueryModePage, an int64
+// for QueryModeCount and a bool for QueryModeExists.
+type QueryHandler func(ctx context.Context, spec QuerySpec) (any, error)
+
+// Middleware wraps the execution of every asset query, local or master. It
+// can rewrite spec before passing it to next, post-process the result of next,
+// or return without calling next at all, in which case it must return the
+// result type QueryHandler documents for spec.Mode. Results may be shared with other
+// callers through the cache, so post-processing must copy before modifying.
+// ToSQL, ToRequest and Explain run the middlewares too, with a next that
+// builds the query from the spec it is given and fails instead of running it.
+type Middleware func(ctx context.Context, spec QuerySpec, next QueryHandler) (any, error)
+
+// chainMiddleware wraps handler with middlewares, the first one outermost
+func chainMiddleware(middlewares []Middleware, handler QueryHandler) QueryHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middleware := middlewares[i]
+		next := handler
+		handler = func(ctx context.Context, spec QuerySpec) (any, error) {
+			return middleware(ctx, spec.Clone(), next)
+		}
+	}
+	return handler
+}
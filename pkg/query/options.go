@@ -0,0 +1,454 @@
+package query
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultPageLimit    = 10
+	defaultMaxPageLimit = 100
+	defaultHttpTimeout  = 30 * time.Second
+
+	defaultApproximateCountThreshold = 100_000
+	defaultTokenIdChunkSize          = 5_000
+)
+
+// Option configures a masterDbConfig created by NewMasterDbConfig
+type Option func(*configOptions) error
+
+type configOptions struct {
+	httpClient          *http.Client
+	transport           http.RoundTripper
+	timeout             time.Duration
+	customTimeout       bool
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	tlsConfig           *tls.Config
+	rootCAs             *x509.CertPool
+	clientCertificates  []tls.Certificate
+	proxy               func(*http.Request) (*url.URL, error)
+	userAgent           string
+	defaultLimit        int
+	maxLimit            int
+	requestsPerSecond   float64
+	burst               int
+	maxInFlight         int
+	coalesceRequests    bool
+	cache               Cache
+	cacheTTL            time.Duration
+	logger              *slog.Logger
+	instrumentation     Instrumentation
+	middlewares         []Middleware
+	approximateCount    int64
+	checksumAddresses   bool
+	excludedOwners      []string
+	tokenIdChunkSize    int
+	compressAbove       int
+}
+
+func defaultConfigOptions() *configOptions {
+	return &configOptions{
+		timeout:          defaultHttpTimeout,
+		defaultLimit:     defaultPageLimit,
+		maxLimit:         defaultMaxPageLimit,
+		coalesceRequests: true,
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		instrumentation:  noopInstrumentation{},
+		approximateCount: defaultApproximateCountThreshold,
+		tokenIdChunkSize: defaultTokenIdChunkSize,
+	}
+}
+
+// WithHttpClient uses the given http.Client for every request to the master.
+// It cannot be combined with the transport, timeout, TLS, proxy and connection
+// pool options, which configure the client it replaces.
+func WithHttpClient(client *http.Client) Option {
+	return func(o *configOptions) error {
+		if client == nil {
+			return errors.New("http client cannot be nil")
+		}
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithTransport uses the given RoundTripper instead of the default transport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *configOptions) error {
+		if transport == nil {
+			return errors.New("transport cannot be nil")
+		}
+		o.transport = transport
+		return nil
+	}
+}
+
+// WithTimeout sets the overall timeout of a single master request
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *configOptions) error {
+		if timeout < 0 {
+			return errors.New("timeout cannot be negative")
+		}
+		o.timeout = timeout
+		o.customTimeout = true
+		return nil
+	}
+}
+
+// WithMaxIdleConns sets the idle connection pool size of the default transport
+func WithMaxIdleConns(total int, perHost int) Option {
+	return func(o *configOptions) error {
+		if total < 0 || perHost < 0 {
+			return errors.New("max idle connections cannot be negative")
+		}
+		o.maxIdleConns = total
+		o.maxIdleConnsPerHost = perHost
+		return nil
+	}
+}
+
+// WithIdleConnTimeout sets how long idle connections are kept in the pool
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(o *configOptions) error {
+		if timeout < 0 {
+			return errors.New("idle connection timeout cannot be negative")
+		}
+		o.idleConnTimeout = timeout
+		return nil
+	}
+}
+
+// WithTLSConfig sets the base TLS configuration of the default transport
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *configOptions) error {
+		if tlsConfig == nil {
+			return errors.New("tls config cannot be nil")
+		}
+		o.tlsConfig = tlsConfig.Clone()
+		return nil
+	}
+}
+
+// WithCACertPEM trusts the PEM encoded CA certificates when connecting to the
+// master, in addition to the system roots
+func WithCACertPEM(pem []byte) Option {
+	return func(o *configOptions) error {
+		if o.rootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			o.rootCAs = pool
+		}
+		if !o.rootCAs.AppendCertsFromPEM(pem) {
+			return errors.New("no valid CA certificate found in PEM data")
+		}
+		return nil
+	}
+}
+
+// WithCACertFile trusts the CA certificates stored in the given PEM file
+func WithCACertFile(path string) Option {
+	return func(o *configOptions) error {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		return WithCACertPEM(pem)(o)
+	}
+}
+
+// WithClientCertificate presents the given certificate for mutual TLS
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(o *configOptions) error {
+		o.clientCertificates = append(o.clientCertificates, cert)
+		return nil
+	}
+}
+
+// WithClientCertificateFiles loads a PEM encoded key pair for mutual TLS
+func WithClientCertificateFiles(certFile string, keyFile string) Option {
+	return func(o *configOptions) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		o.clientCertificates = append(o.clientCertificates, cert)
+		return nil
+	}
+}
+
+// WithProxy routes master requests through the given proxy URL
+func WithProxy(proxyUrl string) Option {
+	return func(o *configOptions) error {
+		parsed, err := url.Parse(proxyUrl)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		o.proxy = http.ProxyURL(parsed)
+		return nil
+	}
+}
+
+// WithProxyFunc selects the proxy of each master request with the given function
+func WithProxyFunc(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(o *configOptions) error {
+		o.proxy = proxy
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent to the master
+func WithUserAgent(userAgent string) Option {
+	return func(o *configOptions) error {
+		o.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithDefaultLimit sets the page size used when a query does not call WithLimit
+func WithDefaultLimit(limit int) Option {
+	return func(o *configOptions) error {
+		if limit < 1 {
+			return errors.New("default limit must be positive")
+		}
+		o.defaultLimit = limit
+		return nil
+	}
+}
+
+// WithMaxLimit sets the largest page size a query may request
+func WithMaxLimit(limit int) Option {
+	return func(o *configOptions) error {
+		if limit < 1 {
+			return errors.New("max limit must be positive")
+		}
+		o.maxLimit = limit
+		return nil
+	}
+}
+
+// WithRateLimit limits master requests to requestsPerSecond with bursts of up
+// to burst requests. Callers wait for a token until their context is done.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(o *configOptions) error {
+		if requestsPerSecond <= 0 {
+			return errors.New("requests per second must be positive")
+		}
+		if burst < 1 {
+			return errors.New("burst must be at least 1")
+		}
+		o.requestsPerSecond = requestsPerSecond
+		o.burst = burst
+		return nil
+	}
+}
+
+// WithMaxInFlight caps the number of concurrent requests to the master
+func WithMaxInFlight(maxInFlight int) Option {
+	return func(o *configOptions) error {
+		if maxInFlight < 1 {
+			return errors.New("max in-flight requests must be positive")
+		}
+		o.maxInFlight = maxInFlight
+		return nil
+	}
+}
+
+// WithRequestCoalescing controls whether concurrent identical queries share a
+// single backend call. It is enabled by default.
+func WithRequestCoalescing(enabled bool) Option {
+	return func(o *configOptions) error {
+		o.coalesceRequests = enabled
+		return nil
+	}
+}
+
+// WithCache caches query results and collection type lookups in cache for ttl,
+// unless a query overrides it with WithCacheTTL or WithNoCache
+func WithCache(cache Cache, ttl time.Duration) Option {
+	return func(o *configOptions) error {
+		if cache == nil {
+			return errors.New("cache cannot be nil")
+		}
+		if ttl <= 0 {
+			return errors.New("cache ttl must be positive")
+		}
+		o.cache = cache
+		o.cacheTTL = ttl
+		return nil
+	}
+}
+
+// WithLogger logs query executions, collection type lookups and failures to
+// logger. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *configOptions) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithInstrumentation reports collection type resolution, count and data
+// queries and master requests to instrumentation
+func WithInstrumentation(instrumentation Instrumentation) Option {
+	return func(o *configOptions) error {
+		if instrumentation == nil {
+			return errors.New("instrumentation cannot be nil")
+		}
+		o.instrumentation = instrumentation
+		return nil
+	}
+}
+
+// WithMiddleware wraps every query execution with middlewares. Middlewares of
+// repeated calls are appended, and the first one added runs outermost.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(o *configOptions) error {
+		for _, middleware := range middlewares {
+			if middleware == nil {
+				return errors.New("middleware cannot be nil")
+			}
+		}
+		o.middlewares = append(o.middlewares, middlewares...)
+		return nil
+	}
+}
+
+// WithApproximateCountThreshold sets the estimated row count from which
+// queries using WithApproximateTotal report the estimate instead of counting
+func WithApproximateCountThreshold(threshold int64) Option {
+	return func(o *configOptions) error {
+		if threshold < 0 {
+			return errors.New("approximate count threshold cannot be negative")
+		}
+		o.approximateCount = threshold
+		return nil
+	}
+}
+
+// WithChecksumAddresses returns owner and collection addresses in results in
+// their EIP-55 checksummed form instead of as stored
+func WithChecksumAddresses() Option {
+	return func(o *configOptions) error {
+		o.checksumAddresses = true
+		return nil
+	}
+}
+
+// DefaultExcludedOwners returns the zero address and the dead address tokens
+// are burned to. Nothing is excluded unless configured, so pass them to
+// WithExcludedOwners to hide burned assets.
+func DefaultExcludedOwners() []string {
+	return []string{
+		"0x0000000000000000000000000000000000000000",
+		"0x000000000000000000000000000000000000dead",
+	}
+}
+
+// WithExcludedOwners excludes the assets of owners, such as burn or treasury
+// addresses, from every query that does not call WithIncludeBurned. Queries
+// that ask for one of owners with WithOwner or WithOwners still get its assets.
+// Assets without an owner are kept.
+func WithExcludedOwners(owners ...string) Option {
+	return func(o *configOptions) error {
+		excluded := make([]string, 0, len(owners))
+		for _, owner := range owners {
+			normalized, err := normalizeAddress(owner)
+			if err != nil {
+				return fmt.Errorf("excluded owner: %w", err)
+			}
+			excluded = append(excluded, normalized)
+		}
+		o.excludedOwners = excluded
+		return nil
+	}
+}
+
+// WithTokenIdChunkSize sets how many token ids one master request carries.
+// Queries with more token ids are split into several requests whose results
+// are merged. Such queries must end within the first max limit assets, must
+// select their order columns when they use WithFields, and report Holders as
+// unknown. The default is 5000.
+func WithTokenIdChunkSize(size int) Option {
+	return func(o *configOptions) error {
+		if size < 1 {
+			return errors.New("token id chunk size must be positive")
+		}
+		o.tokenIdChunkSize = size
+		return nil
+	}
+}
+
+// WithRequestCompression gzip-compresses master request bodies of at least
+// minBytes, sending them with Content-Encoding: gzip. The master must accept
+// compressed bodies.
+func WithRequestCompression(minBytes int) Option {
+	return func(o *configOptions) error {
+		if minBytes < 1 {
+			return errors.New("request compression threshold must be positive")
+		}
+		o.compressAbove = minBytes
+		return nil
+	}
+}
+
+// buildHttpClient creates the http.Client shared by every query of a config
+func (o *configOptions) buildHttpClient() (*http.Client, error) {
+	customTls := o.tlsConfig != nil || o.rootCAs != nil || len(o.clientCertificates) > 0
+	customPool := o.maxIdleConns > 0 || o.maxIdleConnsPerHost > 0 || o.idleConnTimeout > 0
+
+	if o.httpClient != nil {
+		if o.transport != nil || o.customTimeout || customTls || customPool || o.proxy != nil {
+			return nil, errors.New("transport, timeout, TLS, proxy and connection pool options cannot be combined with a custom http client")
+		}
+		return o.httpClient, nil
+	}
+
+	if o.transport != nil {
+		if customTls || customPool || o.proxy != nil {
+			return nil, errors.New("TLS, proxy and connection pool options cannot be combined with a custom transport")
+		}
+		return &http.Client{Transport: o.transport, Timeout: o.timeout}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.maxIdleConns > 0 {
+		transport.MaxIdleConns = o.maxIdleConns
+	}
+	if o.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = o.maxIdleConnsPerHost
+	}
+	if o.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.idleConnTimeout
+	}
+	if o.proxy != nil {
+		transport.Proxy = o.proxy
+	}
+	if customTls {
+		tlsConfig := o.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if o.rootCAs != nil {
+			tlsConfig.RootCAs = o.rootCAs
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, o.clientCertificates...)
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport, Timeout: o.timeout}, nil
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"asset-query/pkg/models"
+)
+
+// benchDSNEnv names the environment variable holding the DSN of a disposable
+// Postgres database for the local page benchmarks. The benchmarks are skipped
+// when it is unset. They create and seed erc_721_collection_assets when the
+// table does not exist yet, and never modify an existing one.
+const benchDSNEnv = "ASSET_QUERY_BENCH_DSN"
+
+const (
+	benchRows       = 200_000
+	benchCollection = "1:0x0091bd12166d29539db6bb37fb79670779abf266"
+	benchTable      = "erc_721_collection_assets"
+)
+
+func openBenchDb(b *testing.B) *sql.DB {
+	b.Helper()
+
+	dsn := os.Getenv(benchDSNEnv)
+	if dsn == "" {
+		b.Skipf("%s is not set", benchDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", benchTable).Scan(&exists); err != nil {
+		b.Fatal(err)
+	}
+	if exists {
+		return db
+	}
+
+	// Two collections with 1000 owners each, created a second apart
+	statements := []string{
+		`CREATE TABLE ` + benchTable + ` (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			chain_id integer NOT NULL,
+			collection_id text NOT NULL,
+			token_id text NOT NULL,
+			owner text,
+			attributes jsonb,
+			created_at timestamptz NOT NULL,
+			updated_at timestamptz NOT NULL,
+			updated_by uuid,
+			signature text NOT NULL DEFAULT ''
+		)`,
+		`INSERT INTO ` + benchTable + ` (chain_id, collection_id, token_id, owner, attributes, created_at, updated_at)
+		SELECT 1,
+			CASE WHEN n % 2 = 0 THEN '` + benchCollection + `' ELSE '1:0x0000000000000000000000000000000000000001' END,
+			n::text,
+			'0x' || lpad(to_hex(n % 1000), 40, '0'),
+			jsonb_build_object('name', 'Token ' || n),
+			timestamptz '2025-01-01' + n * interval '1 second',
+			timestamptz '2025-01-01' + n * interval '1 second'
+		FROM generate_series(1, ` + strconv.Itoa(benchRows) + `) AS n`,
+		`CREATE INDEX ON ` + benchTable + ` (lower(collection_id), created_at, id)`,
+		`ANALYZE ` + benchTable,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return db
+}
+
+func benchSpec() QuerySpec {
+	return QuerySpec{ChainId: 1, CollectionId: benchCollection, Page: 50, Limit: 20, Offset: 49 * 20}
+}
+
+// BenchmarkLocalPageSnapshot runs the count, holders and data statements in
+// one REPEATABLE READ read-only transaction
+func BenchmarkLocalPageSnapshot(b *testing.B) {
+	config := &masterDbConfig{localDb: openBenchDb(b), instrumentation: noopInstrumentation{}}
+	spec := benchSpec()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := queryLocalPage[models.Erc721CollectionAsset](ctx, config, spec, benchTable); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLocalPageSeparate runs the count, holders and data statements on
+// the pool without a transaction, as queries did before the snapshot
+// transaction
+func BenchmarkLocalPageSeparate(b *testing.B) {
+	db := openBenchDb(b)
+	statements, err := localStatements(benchSpec(), benchTable)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := queryCount(ctx, db, statements.Count.SQL, statements.Count.Args); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := queryCount(ctx, db, statements.Holders.SQL, statements.Holders.Args); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := queryRows[models.Erc721CollectionAsset](ctx, db, statements.Data.SQL, statements.Data.Args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLocalPageWindowCount reads the page and its total from a single
+// statement with COUNT(*) OVER(). Postgres has no DISTINCT window aggregate,
+// so this variant cannot count holders, and returns no total past the last
+// page.
+func BenchmarkLocalPageWindowCount(b *testing.B) {
+	db := openBenchDb(b)
+	statements, err := localStatements(benchSpec(), benchTable)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query := strings.Replace(statements.Data.SQL, "SELECT ", "SELECT COUNT(*) OVER() AS total_items, ", 1)
+	ctx := context.Background()
+
+	type windowRow struct {
+		models.Erc721CollectionAsset
+		TotalItems int64 `db:"total_items"`
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := queryRows[windowRow](ctx, db, query, statements.Data.Args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package query
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	sourceLocal  = "local"
+	sourceMaster = "master"
+)
+
+// QueryMode selects what a query returns
+type QueryMode string
+
+const (
+	QueryModePage   QueryMode = ""       // A Pagination of assets
+	QueryModeCount  QueryMode = "count"  // The int64 number of matching assets
+	QueryModeExists QueryMode = "exists" // Whether any asset matches, as a bool
+)
+
+// AssetOrder selects the order of the assets in a page
+type AssetOrder string
+
+const (
+	OrderByCreatedAt AssetOrder = ""           // Oldest assets first
+	OrderByTokenId   AssetOrder = "token_id"   // Token ids in numeric order
+	OrderByUpdatedAt AssetOrder = "updated_at" // Least recently updated assets first, for incremental sync
+)
+
+// QuerySpec is a snapshot of a built asset query. It is passed by value and
+// its slices are copied, so changing it never affects the builder it came from.
+type QuerySpec struct {
+	ChainId          int32      `json:"chainId"`
+	CollectionId     string     `json:"collectionId"`
+	CollectionIds    []string   `json:"collectionIds"` // Further collections, combined with CollectionId
+	TokenIds         []string   `json:"tokenIds"`
+	Owner            string     `json:"owner"`
+	Owners           []string   `json:"owners"`          // Further owners, combined with Owner
+	WithoutOwners    []string   `json:"withoutOwners"`   // Owners to exclude, including the config excluded owners
+	WithoutTokenIds  []string   `json:"withoutTokenIds"` // Token ids to exclude
+	TokenIdFrom      string     `json:"tokenIdFrom"`     // Lowest token id, compared numerically
+	TokenIdTo        string     `json:"tokenIdTo"`       // Highest token id, compared numerically
+	Order            AssetOrder `json:"order"`
+	CreatedAtFrom    time.Time  `json:"createdAtFrom"`
+	CreatedAtTo      time.Time  `json:"createdAtTo"`
+	UpdatedAtFrom    time.Time  `json:"updatedAtFrom"`
+	UpdatedAtTo      time.Time  `json:"updatedAtTo"` // Exclusive
+	UpdatedBy        uuid.UUID  `json:"updatedBy"`   // Updater that last wrote the asset, any when uuid.Nil
+	Filter           Expr       `json:"filter"`
+	Fields           []string   `json:"fields"`           // Columns to return, all columns when empty
+	WithoutTotal     bool       `json:"withoutTotal"`     // Skip counting, report only whether a next page exists
+	ApproximateTotal bool       `json:"approximateTotal"` // Allow planner estimates for large totals
+	FormattedBalance bool       `json:"formattedBalance"` // Format ERC20 balances with the collection decimals
+	MinBalance       string     `json:"minBalance"`       // Lower balance bound in units of the collection decimals
+	MaxBalance       string     `json:"maxBalance"`       // Upper balance bound in units of the collection decimals
+	Mode             QueryMode  `json:"mode"`
+	Page             int        `json:"page"`
+	Limit            int        `json:"limit"`
+	Offset           int        `json:"offset"`
+}
+
+// validSpec takes a snapshot of the builder, or returns the first invalid
+// argument given to it
+func (b *assetQueryBuilderParam) validSpec() (QuerySpec, error) {
+	if b.err != nil {
+		return QuerySpec{}, b.err
+	}
+
+	spec := b.spec()
+	if err := spec.validate(); err != nil {
+		return QuerySpec{}, err
+	}
+	return spec, nil
+}
+
+// validate checks that every "chainId:address" collection id is on the chain
+// of the query
+func (s QuerySpec) validate() error {
+	for _, collectionId := range s.collectionIds() {
+		if !strings.Contains(collectionId, ":") {
+			continue
+		}
+
+		ref, err := ParseCollectionRef(collectionId)
+		if err != nil {
+			return err
+		}
+		if ref.ChainId != s.ChainId {
+			return fmt.Errorf("%w: collection %s is on chain %d, query is on chain %d", ErrChainMismatch, ref, ref.ChainId, s.ChainId)
+		}
+	}
+	return nil
+}
+
+// spec takes a snapshot of the builder. Build must have been called first.
+func (b *assetQueryBuilderParam) spec() QuerySpec {
+	spec := QuerySpec{
+		ChainId: b.chainId,
+		Page:    *b.page,
+		Limit:   *b.limit,
+		Offset:  *b.offset,
+	}
+	if b.collectionId != nil {
+		spec.CollectionId = *b.collectionId
+	}
+	if len(b.collectionIds) > 0 {
+		spec.CollectionIds = slices.Clone(b.collectionIds)
+	}
+	if b.tokenIds != nil {
+		spec.TokenIds = slices.Clone(*b.tokenIds)
+	}
+	if b.owner != nil {
+		spec.Owner = *b.owner
+	}
+	if len(b.owners) > 0 {
+		spec.Owners = slices.Clone(b.owners)
+	}
+	spec.WithoutOwners = slices.Clone(b.withoutOwners)
+	if !b.includeBurned {
+		// Owners asked for explicitly are not excluded by the config
+		requested := spec.owners()
+		for _, owner := range b.config.excludedOwners {
+			if !slices.ContainsFunc(requested, func(r string) bool { return strings.EqualFold(r, owner) }) {
+				spec.WithoutOwners = append(spec.WithoutOwners, owner)
+			}
+		}
+		spec.WithoutOwners = combine("", spec.WithoutOwners)
+	}
+	if len(spec.WithoutOwners) == 0 {
+		spec.WithoutOwners = nil
+	}
+	if len(b.withoutTokenIds) > 0 {
+		spec.WithoutTokenIds = slices.Clone(b.withoutTokenIds)
+	}
+	spec.TokenIdFrom, spec.TokenIdTo = b.tokenIdFrom, b.tokenIdTo
+	spec.Order = b.order
+	if b.createdAtFrom != nil {
+		spec.CreatedAtFrom = *b.createdAtFrom
+	}
+	if b.createdAtTo != nil {
+		spec.CreatedAtTo = *b.createdAtTo
+	}
+	if b.updatedAtFrom != nil {
+		spec.UpdatedAtFrom = *b.updatedAtFrom
+	}
+	if b.updatedAtTo != nil {
+		spec.UpdatedAtTo = *b.updatedAtTo
+	}
+	spec.UpdatedBy = b.updatedBy
+	spec.WithoutTotal = b.withoutTotal
+	spec.ApproximateTotal = b.approximateTotal
+	spec.FormattedBalance = b.formattedBalance
+	if b.minBalance != nil {
+		spec.MinBalance = *b.minBalance
+	}
+	if b.maxBalance != nil {
+		spec.MaxBalance = *b.maxBalance
+	}
+	if len(b.fields) > 0 {
+		spec.Fields = slices.Clone(b.fields)
+	}
+	if len(b.filters) == 1 {
+		spec.Filter = b.filters[0]
+	} else if len(b.filters) > 1 {
+		spec.Filter = And(slices.Clone(b.filters)...)
+	}
+	return spec
+}
+
+// Clone returns a deep copy of the spec, including its filter tree
+func (s QuerySpec) Clone() QuerySpec {
+	s.CollectionIds = slices.Clone(s.CollectionIds)
+	s.TokenIds = slices.Clone(s.TokenIds)
+	s.Owners = slices.Clone(s.Owners)
+	s.WithoutOwners = slices.Clone(s.WithoutOwners)
+	s.WithoutTokenIds = slices.Clone(s.WithoutTokenIds)
+	s.Fields = slices.Clone(s.Fields)
+	s.Filter = cloneExpr(s.Filter)
+	return s
+}
+
+// collectionIds returns CollectionId and CollectionIds without duplicates
+func (s QuerySpec) collectionIds() []string {
+	return combine(s.CollectionId, s.CollectionIds)
+}
+
+// owners returns Owner and Owners without duplicates
+func (s QuerySpec) owners() []string {
+	return combine(s.Owner, s.Owners)
+}
+
+// combine returns first, when set, followed by rest, skipping duplicates
+func combine(first string, rest []string) []string {
+	var combined []string
+	if first != "" {
+		combined = append(combined, first)
+	}
+	for _, value := range rest {
+		if !slices.Contains(combined, value) {
+			combined = append(combined, value)
+		}
+	}
+	return combined
+}
+
+// optional returns a pointer to v, or nil when v is the zero value
+func optional[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
+// requestBody returns the JSON body POSTed to the master /query-builder endpoint
+func (s QuerySpec) requestBody() map[string]interface{} {
+	var tokenIds *[]string
+	if s.TokenIds != nil {
+		tokenIds = &s.TokenIds
+	}
+
+	return map[string]interface{}{
+		"chainId":          s.ChainId,
+		"collectionId":     optional(s.CollectionId),
+		"collectionIds":    s.CollectionIds,
+		"tokenIds":         tokenIds,
+		"owner":            optional(s.Owner),
+		"owners":           s.Owners,
+		"withoutOwners":    s.WithoutOwners,
+		"withoutTokenIds":  s.WithoutTokenIds,
+		"tokenIdFrom":      optional(s.TokenIdFrom),
+		"tokenIdTo":        optional(s.TokenIdTo),
+		"order":            optional(s.Order),
+		"createdAtFrom":    optional(s.CreatedAtFrom),
+		"createdAtTo":      optional(s.CreatedAtTo),
+		"updatedAtFrom":    optional(s.UpdatedAtFrom),
+		"updatedAtTo":      optional(s.UpdatedAtTo),
+		"updatedBy":        optional(s.UpdatedBy),
+		"filter":           s.Filter,
+		"fields":           s.Fields,
+		"withoutTotal":     s.WithoutTotal,
+		"approximateTotal": s.ApproximateTotal,
+		"mode":             optional(s.Mode),
+		"page":             s.Page,
+		"limit":            s.Limit,
+		"offset":           s.Offset,
+	}
+}
+
+// filters returns the typed filters of spec
+func (s QuerySpec) filters() []Filter {
+	var filters []Filter
+
+	switch collectionIds := s.collectionIds(); {
+	case len(collectionIds) == 1:
+		filters = append(filters, EqFold("collection_id", collectionIds[0]))
+	case len(collectionIds) > 1:
+		filters = append(filters, InFold("collection_id", collectionIds...))
+	}
+
+	if len(s.TokenIds) > 0 {
+		filters = append(filters, Filter{Column: "token_id", Op: FilterIn, Values: s.TokenIds})
+	}
+
+	switch owners := s.owners(); {
+	case len(owners) == 1:
+		filters = append(filters, EqFold("owner", owners[0]))
+	case len(owners) > 1:
+		filters = append(filters, InFold("owner", owners...))
+	}
+
+	if len(s.WithoutOwners) > 0 {
+		filters = append(filters, NotInFold("owner", s.WithoutOwners...))
+	}
+
+	if len(s.WithoutTokenIds) > 0 {
+		filters = append(filters, NotIn("token_id", s.WithoutTokenIds...))
+	}
+
+	if s.TokenIdFrom != "" {
+		filters = append(filters, Filter{Column: "token_id", Op: FilterGte, Values: []string{s.TokenIdFrom}, Numeric: true})
+	}
+
+	if s.TokenIdTo != "" {
+		filters = append(filters, Filter{Column: "token_id", Op: FilterLte, Values: []string{s.TokenIdTo}, Numeric: true})
+	}
+
+	if !s.CreatedAtFrom.IsZero() {
+		filters = append(filters, Filter{Column: "created_at", Op: FilterGte, Values: []string{s.CreatedAtFrom.Format(time.RFC3339)}})
+	}
+
+	if !s.CreatedAtTo.IsZero() {
+		filters = append(filters, Filter{Column: "created_at", Op: FilterLte, Values: []string{s.CreatedAtTo.Format(time.RFC3339)}})
+	}
+
+	// Updates are compared to the nanosecond within [from, to), so
+	// consecutive sync windows sharing a bound neither skip nor repeat rows
+	if !s.UpdatedAtFrom.IsZero() {
+		filters = append(filters, Filter{Column: "updated_at", Op: FilterGte, Values: []string{s.UpdatedAtFrom.Format(time.RFC3339Nano)}})
+	}
+
+	if !s.UpdatedAtTo.IsZero() {
+		filters = append(filters, Filter{Column: "updated_at", Op: FilterLt, Values: []string{s.UpdatedAtTo.Format(time.RFC3339Nano)}})
+	}
+
+	if s.UpdatedBy != uuid.Nil {
+		filters = append(filters, Eq("updated_by", s.UpdatedBy.String()))
+	}
+
+	return filters
+}
+
+// where combines the filters of spec and its filter expression with AND
+func (s QuerySpec) where() Expr {
+	where := filtersExpr(s.filters())
+	if s.Filter != nil {
+		where = append(where, s.Filter)
+	}
+	return where
+}
+
+// fingerprint returns a canonical key of the query when run against source.
+// Specs that only differ in the order of their token ids, owners or
+// collection ids share a fingerprint.
+func (s QuerySpec) fingerprint(source string) string {
+	canonical := s.Clone()
+	slices.Sort(canonical.CollectionIds)
+	canonical.CollectionIds = slices.Compact(canonical.CollectionIds)
+	slices.Sort(canonical.Owners)
+	canonical.Owners = slices.Compact(canonical.Owners)
+	slices.Sort(canonical.WithoutOwners)
+	canonical.WithoutOwners = slices.Compact(canonical.WithoutOwners)
+	slices.Sort(canonical.WithoutTokenIds)
+	canonical.WithoutTokenIds = slices.Compact(canonical.WithoutTokenIds)
+	slices.Sort(canonical.TokenIds)
+	canonical.TokenIds = slices.Compact(canonical.TokenIds)
+	slices.Sort(canonical.Fields)
+	canonical.Fields = slices.Compact(canonical.Fields)
+	canonical.CreatedAtFrom = canonical.CreatedAtFrom.UTC()
+	canonical.CreatedAtTo = canonical.CreatedAtTo.UTC()
+	canonical.UpdatedAtFrom = canonical.UpdatedAtFrom.UTC()
+	canonical.UpdatedAtTo = canonical.UpdatedAtTo.UTC()
+
+	payload, _ := json.Marshal(struct {
+		Source string    `json:"source"`
+		Spec   QuerySpec `json:"spec"`
+	}{source, canonical})
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// spanInfo describes an operation executing spec
+func (s QuerySpec) spanInfo(operation Operation, source string, sql string) SpanInfo {
+	return SpanInfo{
+		Operation:    operation,
+		ChainId:      s.ChainId,
+		CollectionId: s.CollectionId,
+		Source:       source,
+		SQL:          sql,
+	}
+}
@@ -0,0 +1,134 @@
+package query
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuerySpecFingerprint(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	base := QuerySpec{
+		ChainId:       1,
+		CollectionId:  "1:0x0091bD12166D29539dB6Bb37fB79670779aBf266",
+		CollectionIds: []string{"1:0xa", "1:0xb"},
+		TokenIds:      []string{"1", "2", "3"},
+		Owners:        []string{"0xa", "0xb"},
+		WithoutOwners: []string{"0xc", "0xd"},
+		Fields:        []string{"owner", "token_id"},
+		CreatedAtFrom: createdAt,
+		UpdatedAtTo:   createdAt.Add(time.Hour),
+		Filter:        Gte("token_id", "10"),
+		Page:          2,
+		Limit:         10,
+		Offset:        10,
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		change func(s *QuerySpec)
+		same   bool
+	}{
+		{"unchanged", sourceLocal, func(s *QuerySpec) {}, true},
+		{"token ids reordered", sourceLocal, func(s *QuerySpec) { s.TokenIds = []string{"3", "1", "2"} }, true},
+		{"token ids repeated", sourceLocal, func(s *QuerySpec) { s.TokenIds = []string{"1", "2", "2", "3", "1"} }, true},
+		{"owners reordered", sourceLocal, func(s *QuerySpec) { s.Owners = []string{"0xb", "0xa"} }, true},
+		{"excluded owners reordered", sourceLocal, func(s *QuerySpec) { s.WithoutOwners = []string{"0xd", "0xc", "0xd"} }, true},
+		{"collection ids reordered", sourceLocal, func(s *QuerySpec) { s.CollectionIds = []string{"1:0xb", "1:0xa"} }, true},
+		{"fields reordered", sourceLocal, func(s *QuerySpec) { s.Fields = []string{"token_id", "owner"} }, true},
+		{"same instant in another zone", sourceLocal, func(s *QuerySpec) {
+			s.CreatedAtFrom = createdAt.In(time.FixedZone("UTC+7", 7*60*60))
+		}, true},
+		{"same instant in local time", sourceLocal, func(s *QuerySpec) { s.UpdatedAtTo = s.UpdatedAtTo.Local() }, true},
+		{"other source", sourceMaster, func(s *QuerySpec) {}, false},
+		{"other chain", sourceLocal, func(s *QuerySpec) { s.ChainId = 2 }, false},
+		{"other page", sourceLocal, func(s *QuerySpec) { s.Page, s.Offset = 3, 20 }, false},
+		{"token id removed", sourceLocal, func(s *QuerySpec) { s.TokenIds = []string{"1", "2"} }, false},
+		{"token ids moved to exclusion", sourceLocal, func(s *QuerySpec) { s.WithoutTokenIds, s.TokenIds = s.TokenIds, nil }, false},
+		{"other instant", sourceLocal, func(s *QuerySpec) { s.CreatedAtFrom = createdAt.Add(time.Nanosecond) }, false},
+		{"other order", sourceLocal, func(s *QuerySpec) { s.Order = OrderByTokenId }, false},
+		{"other filter", sourceLocal, func(s *QuerySpec) { s.Filter = Gte("token_id", "11") }, false},
+		{"without total", sourceLocal, func(s *QuerySpec) { s.WithoutTotal = true }, false},
+		{"other mode", sourceLocal, func(s *QuerySpec) { s.Mode = QueryModeCount }, false},
+	}
+
+	want := base.fingerprint(sourceLocal)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := base.Clone()
+			test.change(&spec)
+			if got := spec.fingerprint(test.source); (got == want) != test.same {
+				t.Errorf("fingerprint %s, base %s, want same = %t", got, want, test.same)
+			}
+		})
+	}
+}
+
+func TestQuerySpecFingerprintKeepsSpec(t *testing.T) {
+	spec := QuerySpec{
+		TokenIds:      []string{"3", "1", "3"},
+		Owners:        []string{"0xb", "0xa"},
+		CreatedAtFrom: time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC+7", 7*60*60)),
+	}
+	spec.fingerprint(sourceLocal)
+
+	if !slices.Equal(spec.TokenIds, []string{"3", "1", "3"}) || !slices.Equal(spec.Owners, []string{"0xb", "0xa"}) {
+		t.Errorf("spec lists modified to %q and %q", spec.TokenIds, spec.Owners)
+	}
+	if spec.CreatedAtFrom.Location().String() != "UTC+7" {
+		t.Errorf("spec time moved to %s", spec.CreatedAtFrom.Location())
+	}
+}
+
+func TestQuerySpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    QuerySpec
+		wantErr error
+	}{
+		{"same chain", QuerySpec{ChainId: 1, CollectionId: "1:" + collectionAddress}, nil},
+		{"bare address", QuerySpec{ChainId: 2, CollectionId: collectionAddress}, nil},
+		{"no collection", QuerySpec{ChainId: 2}, nil},
+		{"other chain", QuerySpec{ChainId: 2, CollectionId: "1:" + collectionAddress}, ErrChainMismatch},
+		{
+			"one of several on another chain",
+			QuerySpec{ChainId: 1, CollectionIds: []string{"1:" + collectionAddress, "2:" + ownerAddress}},
+			ErrChainMismatch,
+		},
+		{"invalid ref", QuerySpec{ChainId: 1, CollectionId: "1:0x0091"}, ErrInvalidCollectionRef},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.spec.validate(); !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryRejectsCollectionOnAnotherChain(t *testing.T) {
+	master := &fakeMaster{}
+	server := httptest.NewServer(master)
+	defer server.Close()
+
+	config, err := NewMasterDbConfig(nil, server.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := config.CreateQueryBuilder().WithChainId(2).
+		WithCollection(CollectionRef{ChainId: 1, Address: collectionAddress}).Build()
+
+	if _, err := query.GetPaginatedAsset(); !errors.Is(err, ErrChainMismatch) {
+		t.Errorf("got error %v, want %v", err, ErrChainMismatch)
+	}
+	if _, err := query.ToRequest(); !errors.Is(err, ErrChainMismatch) {
+		t.Errorf("ToRequest got error %v, want %v", err, ErrChainMismatch)
+	}
+	if got := master.requestCount(); got != 0 {
+		t.Errorf("got %d master queries, want none", got)
+	}
+}
@@ -0,0 +1,299 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rowScanner maps the DB columns of a struct type to its fields. It is built
+// once per type and shared by every query scanning into that type.
+type rowScanner struct {
+	itemType reflect.Type
+	// fields maps a column name to the index path of its field, which is
+	// longer than one for fields promoted from embedded structs
+	fields map[string][]int
+}
+
+// rowScanners caches a *rowScanner per reflect.Type
+var rowScanners sync.Map
+
+// scannerFor returns the cached scanner of itemType, building it on first use
+func scannerFor(itemType reflect.Type) *rowScanner {
+	if cached, ok := rowScanners.Load(itemType); ok {
+		return cached.(*rowScanner)
+	}
+
+	scanner := &rowScanner{itemType: itemType, fields: make(map[string][]int)}
+	scanner.addFields(itemType, nil)
+
+	cached, _ := rowScanners.LoadOrStore(itemType, scanner)
+	return cached.(*rowScanner)
+}
+
+// addFields registers the fields of structType under the index prefix. Fields
+// of embedded structs without a db or json tag are promoted, and fields of the
+// outer struct win over promoted ones with the same column name.
+func (s *rowScanner) addFields(structType reflect.Type, prefix []int) {
+	var embedded []reflect.StructField
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		column, tagged := columnName(field)
+		if column == "-" {
+			continue
+		}
+
+		if field.Anonymous && !tagged {
+			embeddedType := field.Type
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			settable := field.IsExported() || field.Type.Kind() != reflect.Ptr
+			if settable && embeddedType.Kind() == reflect.Struct && !implementsScanner(embeddedType) {
+				embedded = append(embedded, field)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if _, exists := s.fields[column]; !exists {
+			s.fields[column] = append(append([]int{}, prefix...), i)
+		}
+	}
+
+	for _, field := range embedded {
+		embeddedType := field.Type
+		if embeddedType.Kind() == reflect.Ptr {
+			embeddedType = embeddedType.Elem()
+		}
+		s.addFields(embeddedType, append(append([]int{}, prefix...), field.Index...))
+	}
+}
+
+// columnName returns the DB column of a field: its db tag, else its json tag
+// in snake_case, else its name in snake_case. tagged reports whether a tag was used.
+func columnName(field reflect.StructField) (string, bool) {
+	// Check for db tag first
+	if dbTag := field.Tag.Get("db"); dbTag != "" {
+		return strings.Split(dbTag, ",")[0], true
+	}
+
+	// Then check for json tag
+	if jsonTag := field.Tag.Get("json"); jsonTag != "" {
+		// Split the json tag to handle options like omitempty
+		name := strings.Split(jsonTag, ",")[0]
+		if name == "-" {
+			return name, true
+		}
+		if name != "" {
+			return toSnakeCase(name), true
+		}
+	}
+
+	// If no tags present, use field name in snake_case
+	return toSnakeCase(field.Name), false
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+func implementsScanner(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(scannerType)
+}
+
+// columns returns the column names known to the scanner
+func (s *rowScanner) columns() []string {
+	columns := make([]string, 0, len(s.fields))
+	for column := range s.fields {
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// fieldByIndex returns the field at index in item, allocating nil embedded
+// struct pointers on the way
+func fieldByIndex(item reflect.Value, index []int) reflect.Value {
+	field := item
+	for i, fieldIndex := range index {
+		if i > 0 && field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		}
+		field = field.Field(fieldIndex)
+	}
+	return field
+}
+
+// acceptsNull reports whether rows.Scan can store NULL into a fieldType
+// destination. Pointers, slices and sql.Scanner implementations such as the
+// sql.Null* types do; other fields are scanned through a nullable holder.
+func acceptsNull(fieldType reflect.Type) bool {
+	switch fieldType.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice:
+		return true
+	}
+	return implementsScanner(fieldType)
+}
+
+// scanColumn is how one result column is scanned
+type scanColumn struct {
+	index []int
+	// holder is a nullable scan destination, reused for every row, for fields
+	// that cannot store NULL. store copies its value to the field, which is
+	// left zero for NULL.
+	holder interface{}
+	store  func(field reflect.Value) error
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// nullHolder returns a nullable scan destination for a fieldType field and
+// the function storing its value. Strings, numbers, bools and times use the
+// sql.Null* types, which do not allocate per row; other types are scanned
+// through a **T.
+func nullHolder(fieldType reflect.Type) (interface{}, func(field reflect.Value) error) {
+	if fieldType == timeType {
+		holder := new(sql.NullTime)
+		return holder, func(field reflect.Value) error {
+			if holder.Valid {
+				*field.Addr().Interface().(*time.Time) = holder.Time
+			}
+			return nil
+		}
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		holder := new(sql.NullString)
+		return holder, func(field reflect.Value) error {
+			if holder.Valid {
+				field.SetString(holder.String)
+			}
+			return nil
+		}
+	case reflect.Bool:
+		holder := new(sql.NullBool)
+		return holder, func(field reflect.Value) error {
+			if holder.Valid {
+				field.SetBool(holder.Bool)
+			}
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		holder := new(sql.NullInt64)
+		return holder, func(field reflect.Value) error {
+			if !holder.Valid {
+				return nil
+			}
+			if field.OverflowInt(holder.Int64) {
+				return fmt.Errorf("value %d overflows %s", holder.Int64, field.Type())
+			}
+			field.SetInt(holder.Int64)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		holder := new(sql.NullFloat64)
+		return holder, func(field reflect.Value) error {
+			if !holder.Valid {
+				return nil
+			}
+			if field.OverflowFloat(holder.Float64) {
+				return fmt.Errorf("value %g overflows %s", holder.Float64, field.Type())
+			}
+			field.SetFloat(holder.Float64)
+			return nil
+		}
+	}
+
+	holder := reflect.New(reflect.PointerTo(fieldType))
+	return holder.Interface(), func(field reflect.Value) error {
+		value := holder.Elem()
+		if !value.IsNil() {
+			field.Set(value.Elem())
+			value.Set(reflect.Zero(value.Type()))
+		}
+		return nil
+	}
+}
+
+// scanAll scans every row into a T, which may be a struct or a pointer to one.
+// The scan destination slice and the NULL holders are allocated once and
+// reused for every row, and structs are scanned in place into the result.
+func scanAll[T any](rows *sql.Rows, scanner *rowScanner, pointer bool) ([]T, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns: %w", err)
+	}
+
+	// Resolve the field of every result column once per query
+	plan := make([]scanColumn, len(columns))
+	for i, column := range columns {
+		index := scanner.fields[column]
+		if index == nil {
+			continue
+		}
+		plan[i].index = index
+		if fieldType := scanner.itemType.FieldByIndex(index).Type; !acceptsNull(fieldType) {
+			plan[i].holder, plan[i].store = nullHolder(fieldType)
+		}
+	}
+
+	var discard interface{}
+	scanArgs := make([]interface{}, len(columns))
+
+	var items []T
+	var zero T
+	for rows.Next() {
+		// Structs are scanned in place, pointers into a new struct
+		items = append(items, zero)
+		var itemValue reflect.Value
+		if pointer {
+			itemPtr := reflect.New(scanner.itemType)
+			items[len(items)-1] = itemPtr.Interface().(T)
+			itemValue = itemPtr.Elem()
+		} else {
+			itemValue = reflect.ValueOf(&items[len(items)-1]).Elem()
+		}
+
+		for i, column := range plan {
+			switch {
+			case column.index == nil:
+				scanArgs[i] = &discard
+			case column.holder != nil:
+				scanArgs[i] = column.holder
+			default:
+				scanArgs[i] = fieldByIndex(itemValue, column.index).Addr().Interface()
+			}
+		}
+
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		for i, column := range plan {
+			if column.holder == nil {
+				continue
+			}
+			if err := column.store(fieldByIndex(itemValue, column.index)); err != nil {
+				return nil, fmt.Errorf("error scanning column %q: %w", columns[i], err)
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return items, nil
+}
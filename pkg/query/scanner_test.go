@@ -0,0 +1,399 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"asset-query/pkg/models"
+)
+
+// fakeResult is the result every query of a fake database returns
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeDriver serves the fakeResult registered under the data source name
+type fakeDriver struct{}
+
+var (
+	fakeResults   sync.Map
+	fakeDatabases atomic.Int64
+)
+
+func init() {
+	sql.Register("asset-query-fake", fakeDriver{})
+}
+
+// openFakeDb returns a database whose queries all return result
+func openFakeDb(tb testing.TB, result fakeResult) *sql.DB {
+	tb.Helper()
+
+	name := fmt.Sprintf("fake-%d", fakeDatabases.Add(1))
+	fakeResults.Store(name, result)
+
+	db, err := sql.Open("asset-query-fake", name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		db.Close()
+		fakeResults.Delete(name)
+	})
+	return db
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	result, ok := fakeResults.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake result registered as %q", name)
+	}
+	return fakeConn{result.(fakeResult)}, nil
+}
+
+type fakeConn struct {
+	result fakeResult
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt(c), nil
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+
+type fakeStmt struct {
+	result fakeResult
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{result: s.result}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+// erc721Rows returns n rows of every erc_721_collection_assets column
+func erc721Rows(n int) fakeResult {
+	result := fakeResult{
+		columns: []string{"id", "chain_id", "collection_id", "token_id", "owner", "attributes", "created_at", "updated_at", "updated_by", "signature"},
+	}
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		result.rows = append(result.rows, []driver.Value{
+			"2b5d2c49-95f0-4a6c-9a43-6c1c5f0f7c4e",
+			int64(1),
+			"1:0x0091bd12166d29539db6bb37fb79670779abf266",
+			fmt.Sprint(i),
+			"0x821dab5c6fffd8183d4e3e4a5c1725c847c36789",
+			[]byte(`{"name":"Token"}`),
+			createdAt,
+			createdAt,
+			"9f1c1d9e-2f1a-4c4b-8f7e-1d2c3b4a5f6e",
+			"0xsignature",
+		})
+	}
+	return result
+}
+
+func TestQueryRowsEmbeddedStructs(t *testing.T) {
+	type Audit struct {
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	type Named struct {
+		Name string
+		Note string
+	}
+	type item struct {
+		ID string `db:"id"`
+		Audit
+		*Named
+		Note string // Wins over Named.Note
+	}
+
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := openFakeDb(t, fakeResult{
+		columns: []string{"id", "created_at", "updated_at", "name", "note"},
+		rows:    [][]driver.Value{{"a", createdAt, createdAt.Add(time.Hour), "first", "outer"}},
+	})
+
+	items, err := queryRows[item](context.Background(), db, "SELECT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1", len(items))
+	}
+
+	got := items[0]
+	if got.ID != "a" || !got.CreatedAt.Equal(createdAt) || !got.UpdatedAt.Equal(createdAt.Add(time.Hour)) {
+		t.Errorf("embedded struct fields not scanned: %+v", got)
+	}
+	if got.Named == nil || got.Name != "first" {
+		t.Errorf("embedded pointer not allocated and scanned: %+v", got.Named)
+	}
+	if got.Note != "outer" || got.Named.Note != "" {
+		t.Errorf("outer field should win over the promoted one, got %q and %q", got.Note, got.Named.Note)
+	}
+}
+
+func TestQueryRowsSkipsDashTag(t *testing.T) {
+	type item struct {
+		TokenID  string `db:"token_id"`
+		Decimals *int   `db:"-"`
+		Ignored  string `json:"-"`
+	}
+
+	scanner := scannerFor(reflect.TypeOf(item{}))
+	for _, column := range scanner.columns() {
+		if column == "-" || column == "decimals" || column == "ignored" {
+			t.Errorf("column %q should not be mapped", column)
+		}
+	}
+
+	db := openFakeDb(t, fakeResult{
+		columns: []string{"token_id", "decimals", "ignored", "-"},
+		rows:    [][]driver.Value{{"1", int64(18), "x", "y"}},
+	})
+
+	items, err := queryRows[item](context.Background(), db, "SELECT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := items[0]; got.TokenID != "1" || got.Decimals != nil || got.Ignored != "" {
+		t.Errorf("got %+v, want only the token id scanned", got)
+	}
+}
+
+func TestQueryRowsNulls(t *testing.T) {
+	type item struct {
+		Owner     string
+		Balance   models.Uint256
+		Amount    int64
+		Created   time.Time
+		Note      *string
+		Name      sql.NullString
+		Updated   models.NullTime
+		Attribute models.JSON
+	}
+
+	tests := []struct {
+		name  string
+		check func(item) bool
+	}{
+		{"string", func(i item) bool { return i.Owner == "" }},
+		{"scanner", func(i item) bool { return i.Balance.String() == "0" }},
+		{"int", func(i item) bool { return i.Amount == 0 }},
+		{"time", func(i item) bool { return i.Created.IsZero() }},
+		{"pointer", func(i item) bool { return i.Note == nil }},
+		{"null string", func(i item) bool { return !i.Name.Valid }},
+		{"null time", func(i item) bool { return !i.Updated.Valid }},
+		{"json", func(i item) bool { return i.Attribute.IsNull() }},
+	}
+
+	columns := []string{"owner", "balance", "amount", "created", "note", "name", "updated", "attribute"}
+	db := openFakeDb(t, fakeResult{
+		columns: columns,
+		rows: [][]driver.Value{
+			{"0xowner", "10", int64(5), time.Now(), "note", "name", time.Now(), []byte(`{}`)},
+			make([]driver.Value, len(columns)), // A NULL row after a full one
+		},
+	})
+
+	items, err := queryRows[item](context.Background(), db, "SELECT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	if items[0].Owner != "0xowner" || items[0].Amount != 5 || items[0].Note == nil {
+		t.Errorf("full row not scanned: %+v", items[0])
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.check(items[1]) {
+				t.Errorf("NULL %s scanned as %+v", test.name, items[1])
+			}
+		})
+	}
+}
+
+func TestQueryRowsPointerItems(t *testing.T) {
+	db := openFakeDb(t, erc721Rows(3))
+
+	items, err := queryRows[*models.Erc721CollectionAsset](context.Background(), db, "SELECT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+	for i, item := range items {
+		if item == nil || item.TokenID != fmt.Sprint(i) || item.ChainID != 1 {
+			t.Errorf("item %d scanned as %+v", i, item)
+		}
+	}
+}
+
+func TestQueryRowsOverflow(t *testing.T) {
+	type item struct {
+		Amount int8
+	}
+
+	db := openFakeDb(t, fakeResult{
+		columns: []string{"amount"},
+		rows:    [][]driver.Value{{int64(300)}},
+	})
+
+	if _, err := queryRows[item](context.Background(), db, "SELECT", nil); err == nil {
+		t.Error("expected an overflow error")
+	}
+}
+
+const benchScanRows = 10_000
+
+// BenchmarkScanCached scans rows with the cached per-type scanner
+func BenchmarkScanCached(b *testing.B) {
+	db := openFakeDb(b, erc721Rows(benchScanRows))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := queryRows[models.Erc721CollectionAsset](ctx, db, "SELECT", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkScanUncached scans rows the way queryRows did before scanners were
+// cached, rebuilding the column map on every query and the scan destinations
+// on every row
+func BenchmarkScanUncached(b *testing.B) {
+	db := openFakeDb(b, erc721Rows(benchScanRows))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := queryRowsUncached[models.Erc721CollectionAsset](ctx, db, "SELECT", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func queryRowsUncached[T any](ctx context.Context, db queryer, query string, args []interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns: %w", err)
+	}
+
+	var items []T
+	var item T
+	itemType := reflect.TypeOf(item)
+	if itemType.Kind() == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+
+	columnMap := make(map[string]int)
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if dbTag := field.Tag.Get("db"); dbTag != "" {
+			columnMap[dbTag] = i
+			continue
+		}
+		if jsonTag := field.Tag.Get("json"); jsonTag != "" {
+			columnMap[toSnakeCase(strings.Split(jsonTag, ",")[0])] = i
+			continue
+		}
+		columnMap[toSnakeCase(field.Name)] = i
+	}
+
+	for rows.Next() {
+		itemValue := reflect.New(itemType).Elem()
+		scanArgs := make([]interface{}, len(columns))
+
+		for i, colName := range columns {
+			if fieldIndex, ok := columnMap[colName]; ok {
+				field := itemValue.Field(fieldIndex)
+				if field.Kind() == reflect.Ptr {
+					if field.IsNil() {
+						field.Set(reflect.New(field.Type().Elem()))
+					}
+					scanArgs[i] = field.Interface()
+				} else {
+					scanArgs[i] = field.Addr().Interface()
+				}
+			} else {
+				var placeholder interface{}
+				scanArgs[i] = &placeholder
+			}
+		}
+
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		items = append(items, itemValue.Interface().(T))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,156 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ThrottleStats reports how much client side throttling delayed master requests
+type ThrottleStats struct {
+	Requests                   int64         `json:"requests"`                   // Requests that went through the throttle
+	RateLimitedRequests        int64         `json:"rateLimitedRequests"`        // Requests delayed by the rate limiter
+	RateLimitWait              time.Duration `json:"rateLimitWait"`              // Total time spent waiting for tokens
+	ConcurrencyLimitedRequests int64         `json:"concurrencyLimitedRequests"` // Requests delayed by the in-flight cap
+	ConcurrencyWait            time.Duration `json:"concurrencyWait"`            // Total time spent waiting for a free slot
+	Rejected                   int64         `json:"rejected"`                   // Requests whose context ended while waiting
+}
+
+// rateLimiter is a token bucket refilled continuously at rate tokens per second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(requestsPerSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   requestsPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait for it
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved by a caller that stopped waiting
+func (l *rateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
+
+// wait blocks until a token is available or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) (time.Duration, error) {
+	delay := l.reserve()
+	if delay == 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		l.cancel()
+		return time.Since(start), ctx.Err()
+	}
+}
+
+// throttle combines the rate limiter and the max in-flight semaphore of an HttpClient
+type throttle struct {
+	limiter  *rateLimiter
+	inFlight chan struct{}
+
+	requests                   atomic.Int64
+	rateLimitedRequests        atomic.Int64
+	rateLimitWait              atomic.Int64
+	concurrencyLimitedRequests atomic.Int64
+	concurrencyWait            atomic.Int64
+	rejected                   atomic.Int64
+}
+
+func newThrottle(requestsPerSecond float64, burst int, maxInFlight int) *throttle {
+	t := &throttle{}
+	if requestsPerSecond > 0 {
+		t.limiter = newRateLimiter(requestsPerSecond, burst)
+	}
+	if maxInFlight > 0 {
+		t.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return t
+}
+
+// acquire waits for the rate limiter and a free in-flight slot. The returned
+// function releases the slot and must be called once the request is done.
+func (t *throttle) acquire(ctx context.Context) (func(), error) {
+	t.requests.Add(1)
+
+	if t.limiter != nil {
+		waited, err := t.limiter.wait(ctx)
+		if waited > 0 {
+			t.rateLimitedRequests.Add(1)
+			t.rateLimitWait.Add(int64(waited))
+		}
+		if err != nil {
+			t.rejected.Add(1)
+			return nil, err
+		}
+	}
+
+	if t.inFlight == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case t.inFlight <- struct{}{}:
+	default:
+		start := time.Now()
+		t.concurrencyLimitedRequests.Add(1)
+		select {
+		case t.inFlight <- struct{}{}:
+			t.concurrencyWait.Add(int64(time.Since(start)))
+		case <-ctx.Done():
+			t.concurrencyWait.Add(int64(time.Since(start)))
+			t.rejected.Add(1)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() { <-t.inFlight }, nil
+}
+
+func (t *throttle) stats() ThrottleStats {
+	return ThrottleStats{
+		Requests:                   t.requests.Load(),
+		RateLimitedRequests:        t.rateLimitedRequests.Load(),
+		RateLimitWait:              time.Duration(t.rateLimitWait.Load()),
+		ConcurrencyLimitedRequests: t.concurrencyLimitedRequests.Load(),
+		ConcurrencyWait:            time.Duration(t.concurrencyWait.Load()),
+		Rejected:                   t.rejected.Load(),
+	}
+}
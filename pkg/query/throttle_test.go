@@ -0,0 +1,238 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The timing tests only assert lower bounds and generous upper bounds, so a
+// slow machine makes them slower rather than flaky
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := newRateLimiter(10, 3)
+
+	tests := []struct {
+		name     string
+		min, max time.Duration
+	}{
+		{"burst 1", 0, 0},
+		{"burst 2", 0, 0},
+		{"burst 3", 0, 0},
+		{"first queued", time.Nanosecond, 100 * time.Millisecond},
+		{"second queued", time.Nanosecond, 200 * time.Millisecond},
+	}
+
+	var last time.Duration
+	for _, test := range tests {
+		delay := limiter.reserve()
+		if delay < test.min || delay > test.max {
+			t.Errorf("%s: got delay %s, want between %s and %s", test.name, delay, test.min, test.max)
+		}
+		if delay < last {
+			t.Errorf("%s: got delay %s, shorter than the %s of the caller before", test.name, delay, last)
+		}
+		last = delay
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := newRateLimiter(100, 1)
+	limiter.reserve()
+
+	time.Sleep(30 * time.Millisecond)
+	if delay := limiter.reserve(); delay != 0 {
+		t.Errorf("got delay %s after the bucket refilled, want none", delay)
+	}
+
+	// The bucket holds at most burst tokens however long it was idle
+	time.Sleep(50 * time.Millisecond)
+	limiter.reserve()
+	if delay := limiter.reserve(); delay == 0 {
+		t.Error("got a second token from a bucket of one")
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	throttle := newThrottle(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		release, err := throttle.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	if got, want := throttle.stats(), (ThrottleStats{Requests: 100}); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestThrottleRateLimitWaits(t *testing.T) {
+	throttle := newThrottle(20, 1, 0)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := throttle.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	// One token up front, then one every 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 requests at 20/s with a burst of 1 took %s, want at least 100ms", elapsed)
+	}
+	stats := throttle.stats()
+	if stats.Requests != 3 || stats.RateLimitedRequests != 2 || stats.RateLimitWait < 90*time.Millisecond {
+		t.Errorf("got stats %+v, want 3 requests, 2 rate limited for about 150ms", stats)
+	}
+}
+
+func TestThrottleRateLimitCancelReturnsToken(t *testing.T) {
+	throttle := newThrottle(1, 1, 0)
+	if _, err := throttle.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := throttle.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled wait returned after %s, want right after the deadline", elapsed)
+	}
+
+	// The cancelled caller gave its token back, so the next one waits for
+	// the one token still owed rather than two
+	if delay := throttle.limiter.reserve(); delay > time.Second {
+		t.Errorf("got delay %s, want at most 1s", delay)
+	}
+
+	stats := throttle.stats()
+	if stats.Requests != 2 || stats.Rejected != 1 || stats.RateLimitedRequests != 1 {
+		t.Errorf("got stats %+v, want 2 requests, 1 rate limited and rejected", stats)
+	}
+}
+
+func TestThrottleInFlightCap(t *testing.T) {
+	throttle := newThrottle(0, 0, 2)
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := throttle.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		release, err := throttle.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("third request acquired a slot while two were in flight")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	releases[0]()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("third request did not acquire the released slot")
+	}
+	releases[1]()
+
+	stats := throttle.stats()
+	if stats.Requests != 3 || stats.ConcurrencyLimitedRequests != 1 || stats.ConcurrencyWait < 30*time.Millisecond {
+		t.Errorf("got stats %+v, want 3 requests, 1 concurrency limited for at least 30ms", stats)
+	}
+}
+
+func TestThrottleInFlightCancel(t *testing.T) {
+	throttle := newThrottle(0, 0, 1)
+	release, err := throttle.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := throttle.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	release()
+
+	// The rejected caller did not keep a slot
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err = throttle.acquire(ctx)
+	if err != nil {
+		t.Fatalf("slot leaked by the rejected request: %v", err)
+	}
+	release()
+
+	if stats := throttle.stats(); stats.Requests != 3 || stats.Rejected != 1 || stats.ConcurrencyLimitedRequests != 1 {
+		t.Errorf("got stats %+v, want 3 requests, 1 concurrency limited and rejected", stats)
+	}
+}
+
+func TestThrottleStatsOfConfig(t *testing.T) {
+	arrived := make(chan struct{})
+	proceed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-proceed
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	config, err := NewMasterDbConfig(nil, server.URL, true, WithMaxInFlight(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := config.httpClient.DoRequest(context.Background(), "GET", "/", nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// One request reaches the master while the other waits for its slot
+	<-arrived
+	for deadline := time.Now().Add(5 * time.Second); config.ThrottleStats().ConcurrencyLimitedRequests == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("second request never waited for the in-flight cap")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	proceed <- struct{}{}
+	<-arrived
+	proceed <- struct{}{}
+	wg.Wait()
+
+	stats := config.ThrottleStats()
+	if stats.Requests != 2 || stats.ConcurrencyLimitedRequests != 1 || stats.RateLimitedRequests != 0 || stats.Rejected != 0 {
+		t.Errorf("got stats %+v, want 2 requests, 1 concurrency limited", stats)
+	}
+}